@@ -0,0 +1,54 @@
+package deadletter
+
+import (
+	"io"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+)
+
+// ErrNotSupported the sink can only record entries
+var ErrNotSupported = errors.New("operation is not supported by the dead-letter sink")
+
+// ErrNotFound the entry doesn't exist in the sink
+var ErrNotFound = errors.New("dead-letter entry is not found")
+
+// Factories of Sink
+var Factories = map[string]func(cfg Config, ctx context.Context) (Sink, error){}
+
+// Config of dead-letter sink, the sink is disabled if its type is empty
+type Config struct {
+	Sink  string `yaml:"sink" json:"sink"`
+	Path  string `yaml:"path" json:"path"`
+	Topic string `yaml:"topic" json:"topic" default:"$baetyl/function/deadletter"`
+	QOS   uint32 `yaml:"qos" json:"qos" default:"1" validate:"min=0, max=1"`
+}
+
+// Entry an invocation which failed after all attempts
+type Entry struct {
+	ID        string         `json:"id"`
+	Message   baetyl.Message `json:"message"`
+	ErrCode   string         `json:"errCode"`
+	Error     string         `json:"error"`
+	Attempts  int            `json:"attempts"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+// Sink stores dead-lettered invocations
+type Sink interface {
+	Put(entry *Entry) error
+	List() ([]*Entry, error)
+	Get(id string) (*Entry, error)
+	Delete(id string) error
+	io.Closer
+}
+
+// New Sink by config
+func New(cfg Config, ctx context.Context) (Sink, error) {
+	if f, ok := Factories[cfg.Sink]; ok {
+		return f(cfg, ctx)
+	}
+	return nil, errors.Errorf("factory didn't found dead-letter sink according to the type (%s)", cfg.Sink)
+}
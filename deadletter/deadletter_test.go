@@ -0,0 +1,125 @@
+package deadletter
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{
+			name: "file",
+			cfg:  Config{Sink: "file", Path: path.Join(dir, "file", "deadletter.log")},
+		},
+		{
+			name: "spool",
+			cfg:  Config{Sink: "spool", Path: path.Join(dir, "spool")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.cfg, nil)
+			assert.NoError(t, err)
+
+			entries, err := s.List()
+			assert.NoError(t, err)
+			assert.Len(t, entries, 0)
+
+			now := time.Now().UTC()
+			for i, id := range []string{"a", "b", "c"} {
+				err = s.Put(&Entry{
+					ID: id,
+					Message: baetyl.Message{
+						ID:       uint64(i),
+						Metadata: map[string]string{"serviceName": "svc", "invokeId": id},
+						Payload:  []byte("payload-" + id),
+					},
+					ErrCode:   "ERR_FUNCTION_CALL",
+					Error:     "failed",
+					Attempts:  3,
+					Timestamp: now.Add(time.Duration(i) * time.Second),
+				})
+				assert.NoError(t, err)
+			}
+
+			entries, err = s.List()
+			assert.NoError(t, err)
+			assert.Len(t, entries, 3)
+			assert.Equal(t, "a", entries[0].ID)
+			assert.Equal(t, "c", entries[2].ID)
+
+			e, err := s.Get("b")
+			assert.NoError(t, err)
+			assert.Equal(t, "payload-b", string(e.Message.Payload))
+			assert.Equal(t, "b", e.Message.Metadata["invokeId"])
+			assert.Equal(t, 3, e.Attempts)
+
+			err = s.Delete("b")
+			assert.NoError(t, err)
+			err = s.Delete("b")
+			assert.Equal(t, ErrNotFound, err)
+			_, err = s.Get("b")
+			assert.Equal(t, ErrNotFound, err)
+
+			entries, err = s.List()
+			assert.NoError(t, err)
+			assert.Len(t, entries, 2)
+			assert.NoError(t, s.Close())
+
+			// entries survive reopening
+			s, err = New(tt.cfg, nil)
+			assert.NoError(t, err)
+			entries, err = s.List()
+			assert.NoError(t, err)
+			assert.Len(t, entries, 2)
+			assert.NoError(t, s.Close())
+		})
+	}
+
+	_, err = New(Config{Sink: "unknown"}, nil)
+	assert.Error(t, err)
+}
+
+func TestFileCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p := path.Join(dir, "deadletter.log")
+	s, err := New(Config{Sink: "file", Path: p}, nil)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	for _, id := range []string{"a", "b"} {
+		assert.NoError(t, s.Put(&Entry{ID: id, Message: baetyl.Message{Payload: []byte(id)}}))
+	}
+	assert.NoError(t, s.Delete("a"))
+
+	// the deleted entry is removed from the file
+	data, err := ioutil.ReadFile(p)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+	assert.NotContains(t, string(data), `"id":"a"`)
+
+	// the entries are still appended after the rewrite
+	assert.NoError(t, s.Put(&Entry{ID: "c"}))
+	entries, err := s.List()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "b", entries[0].ID)
+	assert.Equal(t, "c", entries[1].ID)
+}
@@ -0,0 +1,161 @@
+package deadletter
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+const defaultFilePath = "var/lib/baetyl/function/deadletter.log"
+
+func init() {
+	Factories["file"] = newFileSink
+}
+
+// fileSink appends entries to a local file, one json per line
+type fileSink struct {
+	path string
+	file *os.File
+	lock sync.Mutex
+}
+
+func newFileSink(cfg Config, _ context.Context) (Sink, error) {
+	p := cfg.Path
+	if p == "" {
+		p = defaultFilePath
+	}
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &fileSink{path: p, file: f}, nil
+}
+
+func (s *fileSink) Put(entry *Entry) error {
+	return s.append(entry)
+}
+
+func (s *fileSink) List() ([]*Entry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.list()
+}
+
+func (s *fileSink) list() ([]*Entry, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+
+	var entries []*Entry
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var e Entry
+			if jerr := json.Unmarshal(line, &e); jerr == nil {
+				entries = append(entries, &e)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return entries, nil
+}
+
+func (s *fileSink) Get(id string) (*Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, e := range entries {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return nil, errors.Trace(ErrNotFound)
+}
+
+// Delete rewrites the file without the entry, so that the file doesn't grow with the deleted ones
+func (s *fileSink) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entries, err := s.list()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var rest []*Entry
+	for _, e := range entries {
+		if e.ID != id {
+			rest = append(rest, e)
+		}
+	}
+	if len(rest) == len(entries) {
+		return errors.Trace(ErrNotFound)
+	}
+	return errors.Trace(s.rewrite(rest))
+}
+
+func (s *fileSink) Close() error {
+	return s.file.Close()
+}
+
+// rewrite writes the entries to a temporary file which then replaces the file, and reopens it for appending
+func (s *fileSink) rewrite(entries []*Entry) error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			f.Close()
+			return errors.Trace(err)
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+	if err = f.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		return errors.Trace(err)
+	}
+
+	s.file.Close()
+	s.file, err = os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	return errors.Trace(err)
+}
+
+func (s *fileSink) append(entry *Entry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.file.Write(append(b, '\n'))
+	return errors.Trace(err)
+}
@@ -0,0 +1,64 @@
+package deadletter
+
+import (
+	"encoding/json"
+
+	"github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/baetyl/baetyl-go/v2/mqtt"
+)
+
+func init() {
+	Factories["mqtt"] = newMqttSink
+}
+
+// mqttSink publishes entries to a topic of the system broker, it keeps nothing locally
+type mqttSink struct {
+	cfg Config
+	cli *mqtt.Client
+	log *log.Logger
+}
+
+func newMqttSink(cfg Config, ctx context.Context) (Sink, error) {
+	cli, err := ctx.NewSystemBrokerClient(nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s := &mqttSink{
+		cfg: cfg,
+		cli: cli,
+		log: log.With(log.Any("deadletter", "mqtt")),
+	}
+	err = cli.Start(mqtt.NewObserverWrapper(nil, nil, func(err error) {
+		s.log.Warn("dead-letter broker client error", log.Error(err))
+	}))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return s, nil
+}
+
+func (s *mqttSink) Put(entry *Entry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.cli.Publish(mqtt.QOS(s.cfg.QOS), s.cfg.Topic, b, 0, false, false))
+}
+
+func (s *mqttSink) List() ([]*Entry, error) {
+	return nil, errors.Trace(ErrNotSupported)
+}
+
+func (s *mqttSink) Get(_ string) (*Entry, error) {
+	return nil, errors.Trace(ErrNotSupported)
+}
+
+func (s *mqttSink) Delete(_ string) error {
+	return errors.Trace(ErrNotSupported)
+}
+
+func (s *mqttSink) Close() error {
+	return s.cli.Close()
+}
@@ -0,0 +1,108 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+const (
+	defaultSpoolPath = "var/lib/baetyl/function/deadletter"
+	spoolFileSuffix  = ".json"
+)
+
+func init() {
+	Factories["spool"] = newSpoolSink
+}
+
+// spoolSink stores every entry as a single file in a directory
+type spoolSink struct {
+	dir string
+}
+
+func newSpoolSink(cfg Config, _ context.Context) (Sink, error) {
+	dir := cfg.Path
+	if dir == "" {
+		dir = defaultSpoolPath
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &spoolSink{dir: dir}, nil
+}
+
+func (s *spoolSink) Put(entry *Entry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// write to a temporary file first so that readers never see partial entries
+	tmp := filepath.Join(s.dir, "."+entry.ID)
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmp, s.file(entry.ID)))
+}
+
+func (s *spoolSink) List() ([]*Entry, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	res := make([]*Entry, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), spoolFileSuffix) || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		e, err := s.Get(strings.TrimSuffix(info.Name(), spoolFileSuffix))
+		if err != nil {
+			continue
+		}
+		res = append(res, e)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Timestamp.Before(res[j].Timestamp)
+	})
+	return res, nil
+}
+
+func (s *spoolSink) Get(id string) (*Entry, error) {
+	b, err := ioutil.ReadFile(s.file(id))
+	if os.IsNotExist(err) {
+		return nil, errors.Trace(ErrNotFound)
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var e Entry
+	err = json.Unmarshal(b, &e)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &e, nil
+}
+
+func (s *spoolSink) Delete(id string) error {
+	err := os.Remove(s.file(id))
+	if os.IsNotExist(err) {
+		return errors.Trace(ErrNotFound)
+	}
+	return errors.Trace(err)
+}
+
+func (s *spoolSink) Close() error {
+	return nil
+}
+
+func (s *spoolSink) file(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+spoolFileSuffix)
+}
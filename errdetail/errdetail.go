@@ -0,0 +1,78 @@
+// Package errdetail implements the structured errors which the runtimes report in the details of grpc status,
+// the detail is a google.protobuf.Struct with the fields 'reason', 'message' and 'retryable'. The proxy reads
+// them to tell the errors of functions from the outage of runtimes, and the runtimes in Go create them here.
+package errdetail
+
+import (
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the reasons of the structured errors
+const (
+	ReasonUserCode         = "USER_CODE_ERROR"
+	ReasonFunctionNotFound = "FUNCTION_NOT_FOUND"
+	ReasonBadInput         = "BAD_INPUT"
+	ReasonTimeout          = "TIMEOUT"
+	// the runtime or the function reaches the max concurrency
+	ReasonResourceExhausted = "RESOURCE_EXHAUSTED"
+)
+
+// Error the structured error reported by a runtime, or returned by an in-process handler
+type Error struct {
+	Reason    string
+	Message   string
+	Retryable bool
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Status returns the grpc status with the error in the details, as the runtimes report it
+func (e *Error) Status(code codes.Code) error {
+	st, err := status.New(code, e.Message).WithDetails(&structpb.Struct{
+		Fields: map[string]*structpb.Value{
+			"reason":    {Kind: &structpb.Value_StringValue{StringValue: e.Reason}},
+			"message":   {Kind: &structpb.Value_StringValue{StringValue: e.Message}},
+			"retryable": {Kind: &structpb.Value_BoolValue{BoolValue: e.Retryable}},
+		},
+	})
+	if err != nil {
+		return status.Error(code, e.Message)
+	}
+	return st.Err()
+}
+
+// New returns the grpc status of the code with the structured error in the details
+func New(code codes.Code, reason, message string, retryable bool) error {
+	return (&Error{Reason: reason, Message: message, Retryable: retryable}).Status(code)
+}
+
+// Of returns the structured error, or the one in the details of the grpc status, nil if there isn't one
+func Of(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil
+	}
+	for _, d := range st.Details() {
+		s, ok := d.(*structpb.Struct)
+		if !ok {
+			continue
+		}
+		reason := s.Fields["reason"].GetStringValue()
+		if reason == "" {
+			continue
+		}
+		return &Error{
+			Reason:    reason,
+			Message:   s.Fields["message"].GetStringValue(),
+			Retryable: s.Fields["retryable"].GetBoolValue(),
+		}
+	}
+	return nil
+}
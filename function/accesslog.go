@@ -0,0 +1,271 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/log"
+	routing "github.com/qiangxue/fasthttp-routing"
+)
+
+// the fields of access log
+const (
+	AccessFieldTime         = "time"
+	AccessFieldInvokeId     = "invokeId"
+	AccessFieldCaller       = "caller"
+	AccessFieldService      = "service"
+	AccessFieldFunction     = "function"
+	AccessFieldMethod       = "method"
+	AccessFieldPath         = "path"
+	AccessFieldStatus       = "status"
+	AccessFieldErrCode      = "errCode"
+	AccessFieldRequestSize  = "requestSize"
+	AccessFieldResponseSize = "responseSize"
+	AccessFieldDuration     = "duration"
+	AccessFieldResolveTime  = "resolveTime"
+	AccessFieldQueueTime    = "queueTime"
+	AccessFieldGrpcTime     = "grpcTime"
+	AccessFieldRetries      = "retries"
+	AccessFieldBackend      = "backend"
+)
+
+const accessRecordKey = "accessRecord"
+
+// trace records how an invocation is processed, it is filled by the invocation and may be
+// read by the request handler while a cancelled invocation is still running
+type trace struct {
+	start    time.Time
+	sent     time.Time
+	resolve  time.Duration
+	grpc     time.Duration
+	attempts int
+	address  string
+	lock     sync.Mutex
+}
+
+type traceKey struct{}
+
+func withTrace(ctx context.Context, t *trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+func traceOf(ctx context.Context) *trace {
+	t, _ := ctx.Value(traceKey{}).(*trace)
+	return t
+}
+
+func (t *trace) resolved(d time.Duration, address string) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	t.resolve += d
+	t.address = address
+	t.lock.Unlock()
+}
+
+func (t *trace) attempted(sent time.Time, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	if t.sent.IsZero() {
+		t.sent = sent
+	}
+	t.grpc += d
+	t.attempts++
+	t.lock.Unlock()
+}
+
+// merge copies what the invocation of a batch records into the trace of one of its requests
+func (t *trace) merge(o *trace) {
+	if t == nil {
+		return
+	}
+	o.lock.Lock()
+	sent, resolve, grpc, attempts, address := o.sent, o.resolve, o.grpc, o.attempts, o.address
+	o.lock.Unlock()
+
+	t.lock.Lock()
+	t.sent, t.resolve, t.grpc, t.attempts, t.address = sent, resolve, grpc, attempts, address
+	t.lock.Unlock()
+}
+
+// accessRecord the details of a request written into access log
+type accessRecord struct {
+	trace
+	invokeId string
+	service  string
+	function string
+	errCode  string
+}
+
+// accessLogger writes one json line for each request
+type accessLogger struct {
+	cfg    AccessLogConfig
+	fields map[string]bool
+	w      io.Writer
+	file   *os.File
+	lock   sync.Mutex
+}
+
+func newAccessLogger(cfg AccessLogConfig) (*accessLogger, error) {
+	l := &accessLogger{cfg: cfg, w: os.Stdout}
+	if len(cfg.Fields) > 0 {
+		l.fields = map[string]bool{}
+		for _, f := range cfg.Fields {
+			l.fields[f] = true
+		}
+	}
+	if cfg.Path != "" {
+		if err := os.MkdirAll(filepath.Dir(cfg.Path), 0755); err != nil {
+			return nil, errors.Trace(err)
+		}
+		f, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		l.file, l.w = f, f
+	}
+	return l, nil
+}
+
+// sampled returns whether the request is logged, the failed requests are always logged
+func (l *accessLogger) sampled(status int) bool {
+	return status >= 400 || l.cfg.SampleRate >= 1 || rand.Float64() < l.cfg.SampleRate
+}
+
+func (l *accessLogger) write(entry map[string]interface{}) error {
+	if l.fields != nil {
+		for k := range entry {
+			if !l.fields[k] {
+				delete(entry, k)
+			}
+		}
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	_, err = l.w.Write(append(b, '\n'))
+	return errors.Trace(err)
+}
+
+// Close closes the log file
+func (l *accessLogger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// logAccess writes the access log after the request is handled, the admin endpoints on the admin listener
+// are not logged
+func (a *API) logAccess(c *routing.Context) error {
+	if a.accessLog == nil {
+		return c.Next()
+	}
+
+	rec := &accessRecord{trace: trace{start: time.Now()}}
+	c.Set(accessRecordKey, rec)
+	err := c.Next()
+
+	status := c.Response.StatusCode()
+	if !a.accessLog.sampled(status) {
+		return err
+	}
+
+	rec.lock.Lock()
+	entry := map[string]interface{}{
+		AccessFieldTime:         rec.start.UTC().Format(time.RFC3339Nano),
+		AccessFieldInvokeId:     rec.invokeId,
+		AccessFieldCaller:       callerOf(c),
+		AccessFieldService:      rec.service,
+		AccessFieldFunction:     rec.function,
+		AccessFieldMethod:       string(c.Method()),
+		AccessFieldPath:         string(c.Path()),
+		AccessFieldStatus:       status,
+		AccessFieldErrCode:      rec.errCode,
+		AccessFieldRequestSize:  len(c.PostBody()),
+		AccessFieldResponseSize: len(c.Response.Body()),
+		AccessFieldDuration:     milliseconds(time.Since(rec.start)),
+		AccessFieldResolveTime:  milliseconds(rec.resolve),
+		AccessFieldQueueTime:    0.0,
+		AccessFieldGrpcTime:     milliseconds(rec.grpc),
+		AccessFieldRetries:      0,
+		AccessFieldBackend:      rec.address,
+	}
+	if !rec.sent.IsZero() {
+		entry[AccessFieldQueueTime] = milliseconds(rec.sent.Sub(rec.start) - rec.resolve)
+	}
+	if rec.attempts > 1 {
+		entry[AccessFieldRetries] = rec.attempts - 1
+	}
+	rec.lock.Unlock()
+
+	if werr := a.accessLog.write(entry); werr != nil {
+		a.log.Warn("failed to write access log", log.Error(werr))
+	}
+	return err
+}
+
+// accessRecordOf returns the access record of the request, nil if access log is disabled
+func accessRecordOf(c *routing.Context) *accessRecord {
+	rec, _ := c.Get(accessRecordKey).(*accessRecord)
+	return rec
+}
+
+// context returns the context in which the invocation records its trace, the trace is kept
+// in the access record if access log is enabled
+func (r *accessRecord) context(ctx context.Context) (context.Context, *trace) {
+	if r == nil {
+		t := &trace{start: time.Now()}
+		return withTrace(ctx, t), t
+	}
+	return withTrace(ctx, &r.trace), &r.trace
+}
+
+// record fills the access record with the message and the error of the request
+func (r *accessRecord) record(message *baetyl.Message, ierr *invokeError) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if message != nil {
+		r.invokeId = message.Metadata["invokeId"]
+		r.service = message.Metadata["serviceName"]
+		r.function = message.Metadata["functionName"]
+	}
+	if ierr != nil {
+		r.errCode = ierr.errCode
+	}
+}
+
+// callerOf returns the common name of the client certificate, or the remote ip if there isn't one
+func callerOf(c *routing.Context) string {
+	if cs := c.TLSConnectionState(); cs != nil && len(cs.PeerCertificates) > 0 {
+		if cn := cs.PeerCertificates[0].Subject.CommonName; cn != "" {
+			return cn
+		}
+	}
+	if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return c.RemoteAddr().String()
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,87 @@
+package function
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLog(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "accesslog")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	s0 := mockGrpc(t, ports[0], serverCert)
+	defer s0.GracefulStop()
+
+	cfg := newMockConfig(t)
+	cfg.AccessLog.Enable = true
+	cfg.AccessLog.Path = path.Join(certPath, "log", "access.log")
+	cfg.AccessLog.Fields = []string{AccessFieldInvokeId, AccessFieldService, AccessFieldFunction, AccessFieldStatus,
+		AccessFieldErrCode, AccessFieldRequestSize, AccessFieldRetries, AccessFieldBackend, AccessFieldGrpcTime}
+	api := newMockAPI(t, cfg, certPath, map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+	})
+
+	resp := doRequest(api, http.MethodPost, "/serviceA/fn", []byte("payload"), map[string]string{"invokeid": "id-1"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	resp = doRequest(api, http.MethodPost, "/serviceX", []byte("payload"), nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+	resp = doAdminRequest(api, http.MethodGet, "/_admin/metrics", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	api.Close()
+
+	f, err := os.Open(cfg.AccessLog.Path)
+	assert.NoError(t, err)
+	defer f.Close()
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	assert.Len(t, entries, 2)
+
+	assert.Len(t, entries[0], len(cfg.AccessLog.Fields))
+	assert.Equal(t, "id-1", entries[0][AccessFieldInvokeId])
+	assert.Equal(t, "serviceA", entries[0][AccessFieldService])
+	assert.Equal(t, "fn", entries[0][AccessFieldFunction])
+	assert.Equal(t, float64(http.StatusOK), entries[0][AccessFieldStatus])
+	assert.Equal(t, "", entries[0][AccessFieldErrCode])
+	assert.Equal(t, float64(len("payload")), entries[0][AccessFieldRequestSize])
+	assert.Equal(t, float64(0), entries[0][AccessFieldRetries])
+	assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", ports[0]), entries[0][AccessFieldBackend])
+	assert.True(t, entries[0][AccessFieldGrpcTime].(float64) > 0)
+
+	assert.Equal(t, "serviceX", entries[1][AccessFieldService])
+	assert.Equal(t, float64(http.StatusNotFound), entries[1][AccessFieldStatus])
+	assert.Equal(t, "ERR_ADDRESS_RESOLVE", entries[1][AccessFieldErrCode])
+	assert.Equal(t, "", entries[1][AccessFieldBackend])
+}
+
+func TestAccessLogSampled(t *testing.T) {
+	l := &accessLogger{cfg: AccessLogConfig{SampleRate: 0}}
+	assert.False(t, l.sampled(http.StatusOK))
+	assert.True(t, l.sampled(http.StatusInternalServerError))
+	l.cfg.SampleRate = 1
+	assert.True(t, l.sampled(http.StatusOK))
+}
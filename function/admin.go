@@ -0,0 +1,87 @@
+package function
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	routing "github.com/qiangxue/fasthttp-routing"
+)
+
+// Metrics the runtime statistics of the proxy
+type Metrics struct {
+	Inflight  int64                   `json:"inflight"`
+	Draining  bool                    `json:"draining"`
+	Cache     map[string]CacheMetrics `json:"cache"`
+	Coalesced map[string]uint64       `json:"coalesced"`
+}
+
+// ReadyResponse the readiness of the proxy
+type ReadyResponse struct {
+	Ready    bool  `json:"ready"`
+	Inflight int64 `json:"inflight"`
+}
+
+// CacheMetrics the statistics of the response cache of a function
+type CacheMetrics struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+// adminEndpoints are served on the admin listener rather than the port of functions
+func (a *API) adminEndpoints() []Endpoint {
+	return []Endpoint{
+		{
+			Methods: []string{http.MethodGet},
+			Route:   "/_admin/metrics",
+			Handler: a.onGetMetrics,
+		},
+		{
+			Methods: []string{http.MethodGet},
+			Route:   "/_admin/ready",
+			Handler: a.onGetReady,
+		},
+	}
+}
+
+// Metrics returns the runtime statistics of the proxy
+func (a *API) Metrics() Metrics {
+	m := Metrics{
+		Inflight:  a.Inflight(),
+		Draining:  a.Draining(),
+		Cache:     map[string]CacheMetrics{},
+		Coalesced: map[string]uint64{},
+	}
+	for name, p := range a.current().policies {
+		if p.flights != nil {
+			m.Coalesced[name] = atomic.LoadUint64(&p.coalesced)
+		}
+		if p.cache == nil {
+			continue
+		}
+		m.Cache[name] = CacheMetrics{
+			Hits:    atomic.LoadUint64(&p.cacheHits),
+			Misses:  atomic.LoadUint64(&p.cacheMisses),
+			Entries: p.cache.Len(),
+		}
+	}
+	return m
+}
+
+func (a *API) onGetMetrics(c *routing.Context) error {
+	b, _ := json.Marshal(a.Metrics())
+	respond(c, http.StatusOK, b)
+	return nil
+}
+
+// onGetReady fails once the api starts to shut down
+func (a *API) onGetReady(c *routing.Context) error {
+	code := http.StatusOK
+	if a.Draining() {
+		code = http.StatusServiceUnavailable
+	}
+	b, _ := json.Marshal(ReadyResponse{Ready: !a.Draining(), Inflight: a.Inflight()})
+	respond(c, code, b)
+	return nil
+}
@@ -2,30 +2,66 @@ package function
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	context2 "github.com/baetyl/baetyl-go/v2/context"
 	"github.com/baetyl/baetyl-go/v2/errors"
 	baetyl "github.com/baetyl/baetyl-go/v2/faas"
 	baetylhttp "github.com/baetyl/baetyl-go/v2/http"
 	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/baetyl/baetyl-go/v2/utils"
 	"github.com/docker/distribution/uuid"
+	"github.com/fasthttp/websocket"
 	routing "github.com/qiangxue/fasthttp-routing"
 	"github.com/valyala/fasthttp"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/baetyl/baetyl-function/v2/deadletter"
+	"github.com/baetyl/baetyl-function/v2/queue"
 	"github.com/baetyl/baetyl-function/v2/resolve"
 )
 
+// how long to wait for the address to be released when the server starts
+const listenTimeout = 5 * time.Second
+
 type API struct {
-	cfg       *Config
-	svr       *baetylhttp.Server
-	manager   Manager
-	endpoints []Endpoint
-	resolver  resolve.Resolver
-	log       *log.Logger
+	// keep the counter first to be 64-bit aligned on 32-bit platforms
+	inflight    int64
+	draining    int32
+	settings    atomic.Value // *settings
+	svr         *baetylhttp.Server
+	ln          net.Listener
+	svrLock     sync.Mutex
+	admin       *baetylhttp.Server
+	adminLn     net.Listener
+	reloadLock  sync.Mutex
+	handler     fasthttp.RequestHandler
+	watcher     *configWatcher
+	ingress     *grpcIngress
+	sockets     map[*websocket.Conn]struct{}
+	socketLock  sync.Mutex
+	manager     Manager
+	accessLog   *accessLogger
+	endpoints   []Endpoint
+	resolver    resolve.Resolver
+	deadLetter  deadletter.Sink
+	queue       *queue.Queue
+	idempotency *idempotency
+	handlers    map[string]Handler
+	log         *log.Logger
+}
+
+// settings the reloadable state of api, it is replaced as a whole so that an invocation
+// always sees the config and the policies of the same version
+type settings struct {
+	cfg      *Config
+	policies map[string]*policy
 }
 
 type Endpoint struct {
@@ -35,43 +71,177 @@ type Endpoint struct {
 }
 
 func NewAPI(cfg Config, ctx context2.Context, resolver resolve.Resolver) (*API, error) {
+	if err := checkPolicies(cfg.Functions); err != nil {
+		return nil, errors.Trace(err)
+	}
+	handlers, err := newHandlers(cfg.Scripts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	cert := ctx.SystemConfig().Certificate
 	m, err := NewManager(cert)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
+	cfg.Server.Address = ":" + context2.FunctionHttpPort()
+	cfg.Server.Certificate = cert
 	api := &API{
-		cfg:      &cfg,
 		manager:  m,
 		resolver: resolver,
+		sockets:  map[*websocket.Conn]struct{}{},
+		handlers: handlers,
 		log:      log.With(log.Any("function", "api")),
 	}
+	if cfg.AccessLog.Enable {
+		api.accessLog, err = newAccessLogger(cfg.AccessLog)
+		if err != nil {
+			m.Close()
+			return nil, errors.Trace(err)
+		}
+	}
+	api.settings.Store(&settings{cfg: &cfg, policies: api.newPolicies(cfg.Functions, nil)})
+	if cfg.Idempotency.Enable {
+		api.idempotency = newIdempotency(cfg.Idempotency)
+	}
+	if cfg.DeadLetter.Sink != "" {
+		api.deadLetter, err = deadletter.New(cfg.DeadLetter, ctx)
+		if err != nil {
+			m.Close()
+			return nil, errors.Trace(err)
+		}
+	}
+	if cfg.Queue.Enable {
+		api.queue, err = queue.New(cfg.Queue, &queueHandler{api: api})
+		if err != nil {
+			m.Close()
+			if api.deadLetter != nil {
+				api.deadLetter.Close()
+			}
+			return nil, errors.Trace(err)
+		}
+	}
+	api.endpoints = append(api.endpoints, api.fanoutEndpoints()...)
+	api.endpoints = append(api.endpoints, api.webSocketEndpoints()...)
 	api.endpoints = append(api.endpoints, api.proxyEndpoints()...)
 
-	handler := api.useRouter()
-	cfg.Server.Address = ":" + context2.FunctionHttpPort()
-	cfg.Server.Certificate = cert
-	api.svr = baetylhttp.NewServer(cfg.Server, handler)
-	api.svr.Start()
+	api.handler = api.useRouter()
+	api.svr, api.ln, err = api.startServer(cfg.Server, api.handler)
+	if err != nil {
+		api.Close()
+		return nil, errors.Trace(err)
+	}
+	api.admin, api.adminLn, err = api.startServer(baetylhttp.ServerConfig{Address: cfg.Admin.Address}, api.useAdminRouter())
+	if err != nil {
+		api.Close()
+		return nil, errors.Trace(err)
+	}
+	if cfg.GrpcServer.Enable {
+		api.ingress, err = newGrpcIngress(api, cfg.GrpcServer, cert)
+		if err != nil {
+			api.Close()
+			return nil, errors.Trace(err)
+		}
+	}
+
+	if f := ctx.ConfFile(); utils.FileExists(f) {
+		api.watcher, err = newConfigWatcher(f, api.Reload)
+		if err != nil {
+			api.log.Warn("failed to watch config file, hot-reload is disabled", log.Any("file", f), log.Error(err))
+		}
+	}
 	return api, nil
 }
 
-// Close closes api
+// Close shuts down api gracefully, it stops accepting new requests and fails the readiness,
+// then waits for the invocations in flight before closing the grpc connections
 func (a *API) Close() {
-	if a.svr != nil {
-		a.svr.Close()
+	if a.watcher != nil {
+		a.watcher.Close()
+	}
+	// the server swapped by a concurrent reload is closed as well
+	a.reloadLock.Lock()
+	defer a.reloadLock.Unlock()
+	atomic.StoreInt32(&a.draining, 1)
+	a.svrLock.Lock()
+	svr, ln := a.svr, a.ln
+	a.svrLock.Unlock()
+	if svr != nil {
+		go svr.Close()
+	}
+	a.drain()
+	if a.ingress != nil {
+		a.ingress.Close()
+	}
+	a.closeSockets()
+	if ln != nil {
+		// the listener is not closed by the server if it is closed before serving
+		ln.Close()
+	}
+	if a.queue != nil {
+		a.queue.Close()
 	}
 	if a.manager != nil {
 		a.manager.Close()
 	}
+	if a.deadLetter != nil {
+		a.deadLetter.Close()
+	}
 	if a.resolver != nil {
 		a.resolver.Close()
 	}
+	if a.accessLog != nil {
+		a.accessLog.Close()
+	}
+	if a.admin != nil {
+		go a.admin.Close()
+	}
+	if a.adminLn != nil {
+		a.adminLn.Close()
+	}
+}
+
+// startServer listens on the address, it waits for a while if the address is still held by the
+// server being replaced
+func (a *API) startServer(cfg baetylhttp.ServerConfig, handler fasthttp.RequestHandler) (*baetylhttp.Server, net.Listener, error) {
+	var ln net.Listener
+	var err error
+	deadline := time.Now().Add(listenTimeout)
+	for {
+		ln, err = net.Listen("tcp4", cfg.Address)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, nil, errors.Trace(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return a.serve(cfg, handler, ln), ln, nil
+}
+
+// serve starts a server on the listener in background
+func (a *API) serve(cfg baetylhttp.ServerConfig, handler fasthttp.RequestHandler, ln net.Listener) *baetylhttp.Server {
+	svr := baetylhttp.NewServer(cfg, handler)
+	go func() {
+		a.log.Info("server is running", log.Any("address", cfg.Address))
+		var err error
+		if cfg.Cert != "" || cfg.Key != "" {
+			err = svr.ServeTLS(ln, cfg.Cert, cfg.Key)
+		} else {
+			err = svr.Serve(ln)
+		}
+		if err != nil {
+			a.log.Error("server shutdown", log.Error(err))
+		}
+	}()
+	return svr
 }
 
 func (a *API) useRouter() fasthttp.RequestHandler {
 	router := routing.New()
+	router.Use(a.logAccess, a.rejectDraining)
 
 	for _, e := range a.endpoints {
 		methods := strings.Join(e.Methods, ",")
@@ -81,6 +251,15 @@ func (a *API) useRouter() fasthttp.RequestHandler {
 	return router.HandleRequest
 }
 
+// useAdminRouter routes the admin endpoints, which are served until the api is closed and aren't logged
+func (a *API) useAdminRouter() fasthttp.RequestHandler {
+	router := routing.New()
+	for _, e := range append(a.adminEndpoints(), a.deadLetterEndpoints()...) {
+		router.To(strings.Join(e.Methods, ","), e.Route, e.Handler)
+	}
+	return router.HandleRequest
+}
+
 func (a *API) proxyEndpoints() []Endpoint {
 	return []Endpoint{
 		{
@@ -103,73 +282,210 @@ func (a *API) onFunctionMessage(c *routing.Context) error {
 
 	a.log.Info("proxy received a request", log.Any("service", serviceName), log.Any("function", functionName))
 
+	invokeId, provided := getInvokeId(c)
+	c.Response.Header.Set(headerInvokeId, invokeId)
+	rec := accessRecordOf(c)
+	ctx, t := rec.context(context.Background())
+	// every response carries the timing, including the ones rejected before the invocation
+	defer a.setTimingHeaders(c, t)
+
+	message := newMessage(serviceName, functionName, invokeId, body)
+	mode, err := parseCloudEvent(c, &message)
+	if err != nil {
+		respondError(c, 400, "ERR_INVALID_CLOUDEVENT", err.Error())
+		return nil
+	}
+	if isAsync(c) {
+		rec.record(&message, nil)
+		a.enqueue(c, &message)
+		return nil
+	}
+
+	timeout, err := getTimeout(c)
+	if err != nil {
+		respondError(c, 400, "ERR_INVALID_TIMEOUT", err.Error())
+		return nil
+	}
+	ctx, cancel := a.withDisconnect(ctx, c)
+	defer cancel()
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	resp, ierr := a.call(ctx, &message, provided)
+	if ierr != nil && ctx.Err() == context.Canceled {
+		// nobody waits for the response, so it is neither retried nor put into dead letters
+		ierr = &invokeError{code: 499, errCode: "ERR_CLIENT_CLOSED", err: ctx.Err(), attempts: ierr.attempts, address: ierr.address}
+		rec.record(&message, ierr)
+		a.respondInvokeError(c, &message, ierr)
+		return nil
+	}
+	rec.record(&message, ierr)
+	if ierr != nil {
+		a.putDeadLetter(&message, ierr)
+		a.respondInvokeError(c, &message, ierr)
+		return nil
+	}
+	if a.current().cfg.CloudEvents.WrapResponse {
+		a.respondCloudEvent(c, mode, &message, resp.Payload)
+		return nil
+	}
+	respond(c, http.StatusOK, resp.Payload)
+	return nil
+}
+
+// getInvokeId returns the invokeId given by the caller, or generates a new one
+func getInvokeId(c *routing.Context) (string, bool) {
 	invokeId := string(c.RequestCtx.Request.Header.Peek("invokeid"))
 	if invokeId == "" {
-		invokeId = uuid.Generate().String()
+		return newInvokeId(), false
 	}
+	return invokeId, true
+}
 
+func newInvokeId() string {
+	return uuid.Generate().String()
+}
+
+func newMessage(serviceName, functionName, invokeId string, body []byte) baetyl.Message {
 	metedata := map[string]string{
 		"serviceName":  serviceName,
 		"functionName": functionName,
 		"invokeId":     invokeId,
 	}
-	message := baetyl.Message{
+	return baetyl.Message{
 		Payload:  body,
 		Metadata: metedata,
 	}
+}
 
+func (a *API) current() *settings {
+	return a.settings.Load().(*settings)
+}
+
+// call invokes the message through the policies, idempotent means the invokeId is given by the caller
+// and the invocation can be deduplicated
+func (a *API) call(ctx context.Context, message *baetyl.Message, idempotent bool) (*baetyl.Message, *invokeError) {
+	defer a.track()()
+	if a.idempotency != nil && idempotent {
+		return a.callIdempotent(ctx, message)
+	}
+	return a.callFunction(ctx, message)
+}
+
+// invokeError describes a failed invocation and how it is reported to the caller
+type invokeError struct {
+	code     int
+	errCode  string
+	err      error
+	attempts int
+	address  string
+	grpcCode string
+	// exhausted the invocation still failed after all attempts, only such ones are dead-lettered
+	exhausted bool
+}
+
+func (e *invokeError) Error() string {
+	return e.err.Error()
+}
+
+// waitRetry waits before the retry, the interval doubles for every retry. It returns false once ctx is done
+func waitRetry(ctx context.Context, backoff time.Duration, retry int) bool {
+	if backoff <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(backoff << uint(retry-1))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// invoke resolves the backend of the message's service and calls it with retries, every attempt
+// is bounded by both ctx and the timeout of the function, and the deadline is passed to the runtime.
+// The services registered in Handlers and the script services are called in process.
+func (a *API) invoke(ctx context.Context, message *baetyl.Message) (*baetyl.Message, *invokeError) {
+	serviceName := message.Metadata["serviceName"]
+	if handler, ok := a.handlers[serviceName]; ok {
+		return a.invokeLocal(ctx, handler, message)
+	}
+	functionName := message.Metadata["functionName"]
+	cfg := a.current().cfg.Client.Grpc
+	timeout := a.timeoutOf(message)
+	t := traceOf(ctx)
+
+	begin := time.Now()
 	address, err := a.resolver.Resolve(serviceName)
+	t.resolved(time.Since(begin), address)
 	if err != nil {
 		a.log.Debug("resolve service's address failed", log.Error(err))
-		respondError(c, 404, "ERR_ADDRESS_RESOLVE", err.Error())
-		return nil
+		return nil, &invokeError{code: 404, errCode: "ERR_ADDRESS_RESOLVE", err: err}
 	}
 
 	conn, err := a.manager.GetGRPCConnection(address, false)
 	if err != nil {
 		a.log.Debug("get grpc conn failed", log.Error(err))
-		respondError(c, 500, "ERR_GET_GRPC_CONN", err.Error())
-		return nil
+		return nil, &invokeError{code: 500, errCode: "ERR_GET_GRPC_CONN", err: err, address: address}
 	}
 
-	for i := 0; i < a.cfg.Client.Grpc.Retries; i++ {
-		ctx, cancel := context.WithTimeout(context.Background(), a.cfg.Client.Grpc.Timeout)
+	var last error
+	for i := 0; i < cfg.Retries; i++ {
+		if i > 0 && !waitRetry(ctx, cfg.Backoff, i) {
+			ierr := callError(contextError(ctx), i)
+			ierr.address = address
+			return nil, ierr
+		}
+		cctx, cancel := context.WithTimeout(ctx, timeout)
+		deadline, _ := cctx.Deadline()
 
 		client := baetyl.NewFunctionClient(conn)
-		resp, err := client.Call(ctx, &message)
+		sent := time.Now()
+		resp, err := client.Call(cctx, withDeadline(message, deadline))
+		t.attempted(sent, time.Since(sent))
 		cancel()
 		if err == nil {
 			a.log.Debug("call function successfully", log.Any("service", serviceName), log.Any("function", functionName))
-			respond(c, http.StatusOK, resp.Payload)
-			return nil
+			return resp, nil
 		}
+		last = err
 
 		code := status.Code(err)
 		if code == codes.Unavailable || code == codes.Unauthenticated {
 			a.log.Debug("function service is unavailable or unauthenticated with retry", log.Any("retry", i+1), log.Error(err))
+			begin = time.Now()
 			address, err = a.resolver.Resolve(serviceName)
+			t.resolved(time.Since(begin), address)
 			if err != nil {
 				a.log.Debug("resolve service's address failed with retry", log.Any("retry", i+1), log.Error(err))
-				respondError(c, 404, "ERR_ADDRESS_RESOLVE", err.Error())
-				return nil
+				return nil, &invokeError{code: 404, errCode: "ERR_ADDRESS_RESOLVE", err: err, attempts: i + 1}
 			}
 
 			conn, err = a.manager.GetGRPCConnection(address, false)
 			if err != nil {
 				a.log.Debug("get grpc conn failed with retry", log.Any("retry", i+1), log.Error(err))
-				respondError(c, 500, "ERR_GET_GRPC_CONN", err.Error())
-				return nil
+				return nil, &invokeError{code: 500, errCode: "ERR_GET_GRPC_CONN", err: err, attempts: i + 1, address: address}
 			}
 			continue
 		}
+		if rerr := runtimeErrorOf(err); rerr != nil && rerr.Retryable && ctx.Err() == nil {
+			a.log.Debug("function reports a retryable error", log.Any("retry", i+1), log.Any("reason", rerr.Reason), log.Error(err))
+			continue
+		}
 
 		a.log.Debug("call function failed", log.Any("service", serviceName), log.Any("function", functionName), log.Error(err))
-		respondError(c, 500, "ERR_FUNCTION_CALL", err.Error())
-		return nil
+		ierr := callError(err, i+1)
+		ierr.address = address
+		return nil, ierr
 	}
 
-	err = errors.Errorf("failed to invoke target %s after %v retries", address, a.cfg.Client.Grpc.Retries)
-	a.log.Debug("call function failed", log.Any("service", serviceName), log.Any("function", functionName), log.Error(err))
-	respondError(c, 500, "ERR_FUNCTION_CALL", err.Error())
-	return nil
+	ierr := callError(last, cfg.Retries)
+	ierr.address = address
+	ierr.exhausted = true
+	ierr.err = errors.Errorf("failed to invoke target %s after %v retries: %v", address, cfg.Retries, last)
+	a.log.Debug("call function failed", log.Any("service", serviceName), log.Any("function", functionName), log.Error(ierr.err))
+	return nil, ierr
 }
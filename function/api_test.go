@@ -9,6 +9,7 @@ import (
 	"net"
 	"os"
 	"path"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -21,6 +22,7 @@ import (
 	"github.com/baetyl/baetyl-go/v2/native"
 	"github.com/baetyl/baetyl-go/v2/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
@@ -90,6 +92,7 @@ func TestServerNativeNormal(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEmpty(t, api)
 	defer api.Close()
+	waitServer(t, "localhost:50011")
 
 	cert := utils.Certificate{
 		CA:   path.Join(certPath, "ca.pem"),
@@ -143,14 +146,31 @@ func TestServerNativeNormal(t *testing.T) {
 }
 
 type mockGrpcServer struct {
-	port int
+	port  int
+	delay time.Duration
+	calls int32
 }
 
 func (m *mockGrpcServer) Call(ctx context2.Context, msg *baetyl.Message) (*baetyl.Message, error) {
+	atomic.AddInt32(&m.calls, 1)
+	if m.delay > 0 {
+		select {
+		case <-time.After(m.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	// a batch is echoed, so every caller gets its own payload back
+	if msg.Metadata["batch"] == "true" {
+		return msg, nil
+	}
 	body := string(msg.Payload)
 	if body == "error" {
 		return nil, errors.New("err")
 	} else {
+		if body == "nostore" {
+			msg.Metadata["cacheControl"] = "no-store"
+		}
 		o := map[string]int{
 			"port": m.port,
 		}
@@ -161,12 +181,15 @@ func (m *mockGrpcServer) Call(ctx context2.Context, msg *baetyl.Message) (*baety
 }
 
 func mockGrpc(t *testing.T, port int, cert utils.Certificate) *grpc.Server {
+	return mockGrpcServe(t, port, cert, &mockGrpcServer{port: port})
+}
+
+func mockGrpcServe(t *testing.T, port int, cert utils.Certificate, grpcServer baetyl.FunctionServer) *grpc.Server {
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	assert.NoError(t, err)
 	tlsCfg, err := utils.NewTLSConfigServer(cert)
 	assert.NoError(t, err)
 	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsCfg)))
-	grpcServer := &mockGrpcServer{port: port}
 	baetyl.RegisterFunctionServer(s, grpcServer)
 	go func() {
 		fmt.Printf("-----> grpc server is running at: %d with tls <-----\n", port)
@@ -194,6 +217,83 @@ func getFreePorts(n int) ([]int, error) {
 	return ports, nil
 }
 
+func waitServer(t *testing.T, address string) {
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	t.Fatalf("server %s is not ready", address)
+}
+
+// newMockAPI starts an api whose backends are resolved by the given address mapping,
+// requests are passed to its handler directly by doRequest
+func newMockAPI(t *testing.T, cfg Config, certPath string, addresses map[string]string) *API {
+	ctx := &mockContext{
+		cert: utils.Certificate{
+			CA:   path.Join(certPath, "ca.pem"),
+			Key:  path.Join(certPath, "clientKey.pem"),
+			Cert: path.Join(certPath, "clientCrt.pem"),
+		},
+	}
+	api, err := NewAPI(cfg, ctx, &mockResolver{addresses: addresses})
+	assert.NoError(t, err)
+	return api
+}
+
+func newMockConfig(t *testing.T) Config {
+	var cfg Config
+	err := utils.UnmarshalYAML(nil, &cfg)
+	assert.NoError(t, err)
+	cfg.Client.Grpc.Timeout = 2 * time.Second
+	cfg.Admin.Address = "127.0.0.1:0"
+	return cfg
+}
+
+func doRequest(api *API, method, uri string, body []byte, headers map[string]string) *fasthttp.Response {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	ctx.Request.SetBody(body)
+	for k, v := range headers {
+		ctx.Request.Header.Set(k, v)
+	}
+	api.svr.Handler(ctx)
+	resp := &fasthttp.Response{}
+	ctx.Response.CopyTo(resp)
+	return resp
+}
+
+// doAdminRequest passes the request to the handler of the admin listener
+func doAdminRequest(api *API, method, uri string, body []byte) *fasthttp.Response {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	ctx.Request.SetBody(body)
+	api.admin.Handler(ctx)
+	resp := &fasthttp.Response{}
+	ctx.Response.CopyTo(resp)
+	return resp
+}
+
+type mockResolver struct {
+	addresses map[string]string
+}
+
+func (m *mockResolver) Resolve(service string) (string, error) {
+	if address, ok := m.addresses[service]; ok {
+		return address, nil
+	}
+	return "", errors.Errorf("service (%s) not found", service)
+}
+
+func (m *mockResolver) Close() error {
+	return nil
+}
+
 type mockContext struct {
 	cert utils.Certificate
 	ns   string
@@ -0,0 +1,90 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/log"
+	routing "github.com/qiangxue/fasthttp-routing"
+
+	"github.com/baetyl/baetyl-function/v2/queue"
+)
+
+const headerAsync = "X-Baetyl-Async"
+
+// AsyncResponse the response of an invocation accepted by the queue
+type AsyncResponse struct {
+	InvokeId string `json:"invokeId"`
+	Queued   bool   `json:"queued"`
+}
+
+// queueHandler delivers queued messages through the api
+type queueHandler struct {
+	api *API
+}
+
+// Deliver calls the message as a request does, so that the policies of the function apply and the call is
+// waited for when shutting down
+func (h *queueHandler) Deliver(ctx context.Context, msg *baetyl.Message) error {
+	_, ierr := h.api.call(ctx, msg, false)
+	if ierr == nil {
+		return nil
+	}
+	if !redeliverable(ierr) {
+		return queue.Permanent(ierr)
+	}
+	return ierr
+}
+
+func (h *queueHandler) Drop(msg *baetyl.Message, err error, attempts int) {
+	ierr, ok := err.(*invokeError)
+	if !ok {
+		ierr = &invokeError{code: 500, errCode: "ERR_FUNCTION_CALL", err: err}
+	}
+	ierr.attempts = attempts
+	ierr.exhausted = true
+	h.api.putDeadLetter(msg, ierr)
+}
+
+// redeliverable tells if delivering the message again may succeed. The runtimes may recover from the outage,
+// while the errors which the function reports at once, such as bad inputs, are retried only if marked retryable
+func redeliverable(ierr *invokeError) bool {
+	if ierr.exhausted || ierr.errCode == "ERR_ADDRESS_RESOLVE" || ierr.errCode == "ERR_GET_GRPC_CONN" {
+		return true
+	}
+	if rerr := runtimeErrorOf(ierr.err); rerr != nil {
+		return rerr.Retryable
+	}
+	return false
+}
+
+func isAsync(c *routing.Context) bool {
+	return strings.EqualFold(string(c.RequestCtx.Request.Header.Peek(headerAsync)), "true")
+}
+
+// enqueue stores the message into the durable queue and responds at once,
+// the message is delivered later in order with the others of the same function
+func (a *API) enqueue(c *routing.Context, message *baetyl.Message) {
+	if a.queue == nil {
+		respondError(c, 400, "ERR_ASYNC_DISABLED", "async invocation is not enabled")
+		return
+	}
+
+	invokeId := message.Metadata["invokeId"]
+	key := message.Metadata["serviceName"] + "/" + message.Metadata["functionName"]
+	queued, err := a.queue.Push(key, invokeId, message)
+	if err != nil {
+		a.log.Error("failed to push message into queue", log.Any("invokeId", invokeId), log.Error(err))
+		respondError(c, 500, "ERR_ASYNC_QUEUE", err.Error())
+		return
+	}
+	if !queued {
+		a.log.Debug("duplicated message is ignored", log.Any("invokeId", invokeId))
+	}
+
+	b, _ := json.Marshal(&AsyncResponse{InvokeId: invokeId, Queued: queued})
+	respond(c, http.StatusAccepted, b)
+}
@@ -0,0 +1,76 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsync(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "async")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	s0 := mockGrpc(t, ports[0], serverCert)
+	defer s0.GracefulStop()
+
+	addresses := map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+	}
+
+	api := newMockAPI(t, newMockConfig(t), certPath, addresses)
+	resp := doRequest(api, http.MethodPost, "/serviceA", []byte("payload"), map[string]string{headerAsync: "true"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	api.Close()
+
+	cfg := newMockConfig(t)
+	cfg.Queue.Enable = true
+	cfg.Queue.Path = path.Join(certPath, "queue.db")
+	cfg.DeadLetter.Sink = "spool"
+	cfg.DeadLetter.Path = path.Join(certPath, "spool")
+	api = newMockAPI(t, cfg, certPath, addresses)
+	defer api.Close()
+
+	resp = doRequest(api, http.MethodPost, "/serviceA/fn", []byte("payload"), map[string]string{headerAsync: "true", "invokeid": "invoke-1"})
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode())
+	var ar AsyncResponse
+	assert.NoError(t, json.Unmarshal(resp.Body(), &ar))
+	assert.Equal(t, AsyncResponse{InvokeId: "invoke-1", Queued: true}, ar)
+
+	resp = doRequest(api, http.MethodPost, "/serviceA/fn", []byte("payload"), map[string]string{headerAsync: "true", "invokeid": "invoke-1"})
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode())
+	assert.NoError(t, json.Unmarshal(resp.Body(), &ar))
+	assert.Equal(t, AsyncResponse{InvokeId: "invoke-1", Queued: false}, ar)
+
+	// the message failing for good is dead-lettered at once rather than retried up to the max attempts
+	resp = doRequest(api, http.MethodPost, "/serviceA/fn", []byte("error"), map[string]string{headerAsync: "true", "invokeid": "invoke-2"})
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode())
+
+	assert.Eventually(t, func() bool {
+		return api.queue.Len("serviceA/fn") == 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	entries, err := api.deadLetter.List()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "invoke-2", entries[0].Message.Metadata["invokeId"])
+	assert.Equal(t, 1, entries[0].Attempts)
+}
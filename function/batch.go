@@ -0,0 +1,236 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/docker/distribution/uuid"
+)
+
+// metadata keys of a batch message
+const (
+	metadataBatch          = "batch"
+	metadataBatchSize      = "batchSize"
+	metadataBatchInvokeIds = "batchInvokeIds"
+)
+
+type batchResult struct {
+	resp *baetyl.Message
+	err  *invokeError
+}
+
+type batchItem struct {
+	ctx     context.Context
+	message *baetyl.Message
+	element json.RawMessage
+	// quoted the payload isn't json, and is put into the batch as a json string
+	quoted bool
+	trace  *trace
+	result chan batchResult
+}
+
+// pendingBatch the items of a function waiting to be sent
+type pendingBatch struct {
+	items []*batchItem
+	timer *time.Timer
+}
+
+// batcher collects the invocations of a function for up to max size messages or the window,
+// and sends them in one message whose payload is a json array. The policy of a service applies
+// to all its functions, so the invocations are batched per function
+type batcher struct {
+	cfg     BatchConfig
+	pending map[string]*pendingBatch
+	lock    sync.Mutex
+	invoke  func(context.Context, *baetyl.Message) (*baetyl.Message, *invokeError)
+	log     *log.Logger
+}
+
+func newBatcher(cfg BatchConfig, invoke func(context.Context, *baetyl.Message) (*baetyl.Message, *invokeError)) *batcher {
+	return &batcher{
+		cfg:     cfg,
+		pending: map[string]*pendingBatch{},
+		invoke:  invoke,
+		log:     log.With(log.Any("function", "batcher")),
+	}
+}
+
+// Do adds the message into the pending batch of its function and waits for its own part of the batch response
+func (b *batcher) Do(ctx context.Context, message *baetyl.Message) (*baetyl.Message, *invokeError) {
+	item := &batchItem{
+		ctx:     ctx,
+		message: message,
+		element: batchElement(message.Payload),
+		quoted:  !json.Valid(message.Payload),
+		trace:   traceOf(ctx),
+		result:  make(chan batchResult, 1),
+	}
+	key := message.Metadata["functionName"]
+
+	b.lock.Lock()
+	pb, ok := b.pending[key]
+	if !ok {
+		pb = &pendingBatch{}
+		b.pending[key] = pb
+	}
+	pb.items = append(pb.items, item)
+	if len(pb.items) >= b.cfg.MaxSize {
+		items := b.take(key)
+		b.lock.Unlock()
+		go b.flush(items)
+	} else {
+		if len(pb.items) == 1 {
+			pb.timer = time.AfterFunc(b.cfg.Window, func() { b.expire(key, pb) })
+		}
+		b.lock.Unlock()
+	}
+
+	select {
+	case r := <-item.result:
+		return r.resp, r.err
+	case <-ctx.Done():
+		return nil, callError(contextError(ctx), 0)
+	}
+}
+
+// take removes the pending items of the function, the lock must be held
+func (b *batcher) take(key string) []*batchItem {
+	pb, ok := b.pending[key]
+	if !ok {
+		return nil
+	}
+	delete(b.pending, key)
+	if pb.timer != nil {
+		pb.timer.Stop()
+	}
+	return pb.items
+}
+
+// expire flushes the batch whose window ends, unless it's already taken, since the timer may fire after being stopped
+func (b *batcher) expire(key string, pb *pendingBatch) {
+	b.lock.Lock()
+	var items []*batchItem
+	if b.pending[key] == pb {
+		items = b.take(key)
+	}
+	b.lock.Unlock()
+	if len(items) > 0 {
+		b.flush(items)
+	}
+}
+
+// flush sends the batch before the earliest deadline of the items, and cancels it once all callers are gone
+func (b *batcher) flush(items []*batchItem) {
+	first := items[0].message
+	payload := make([]json.RawMessage, len(items))
+	invokeIds := make([]string, len(items))
+	for i, item := range items {
+		payload[i] = item.element
+		invokeIds[i] = item.message.Metadata["invokeId"]
+	}
+	body, _ := json.Marshal(payload)
+	ids, _ := json.Marshal(invokeIds)
+	message := &baetyl.Message{
+		Payload: body,
+		Metadata: map[string]string{
+			"serviceName":          first.Metadata["serviceName"],
+			"functionName":         first.Metadata["functionName"],
+			"invokeId":             uuid.Generate().String(),
+			metadataBatch:          "true",
+			metadataBatchSize:      strconv.Itoa(len(items)),
+			metadataBatchInvokeIds: string(ids),
+		},
+	}
+
+	b.log.Debug("send batch", log.Any("size", len(items)), log.Any("invokeId", message.Metadata["invokeId"]))
+
+	t := &trace{}
+	ctx, cancel := batchContext(withTrace(context.Background(), t), items)
+	resp, ierr := b.invoke(ctx, message)
+	cancel()
+	var parts []json.RawMessage
+	if ierr == nil {
+		if err := json.Unmarshal(resp.Payload, &parts); err != nil || len(parts) != len(items) {
+			err = errors.Errorf("the response of batch should be a json array of %d elements", len(items))
+			ierr = &invokeError{code: 500, errCode: "ERR_BATCH_RESPONSE", err: err, attempts: 1}
+		}
+	}
+
+	for i, item := range items {
+		item.trace.merge(t)
+		if ierr != nil {
+			item.result <- batchResult{err: ierr}
+			continue
+		}
+		item.result <- batchResult{resp: &baetyl.Message{
+			ID:       item.message.ID,
+			Metadata: item.message.Metadata,
+			Payload:  splitBatchElement(item, parts[i]),
+		}}
+	}
+}
+
+// batchContext returns the context of the batch, which has the earliest deadline of the items,
+// and is cancelled once the contexts of all items are done
+func batchContext(parent context.Context, items []*batchItem) (context.Context, context.CancelFunc) {
+	var deadline time.Time
+	for _, item := range items {
+		if d, ok := item.ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+			deadline = d
+		}
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if deadline.IsZero() {
+		ctx, cancel = context.WithCancel(parent)
+	} else {
+		ctx, cancel = context.WithDeadline(parent, deadline)
+	}
+	go func() {
+		for _, item := range items {
+			select {
+			case <-item.ctx.Done():
+			case <-ctx.Done():
+				return
+			}
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// batchElement puts a copy of the payload into the batch if it is json, otherwise a json string of it
+func batchElement(payload []byte) json.RawMessage {
+	if json.Valid(payload) {
+		return append(json.RawMessage{}, payload...)
+	}
+	b, _ := json.Marshal(string(payload))
+	return b
+}
+
+// splitBatchElement returns the part of the batch response for the item, a json string element is unquoted only
+// if the payload of the item was quoted, so that a json payload gets the element as is. Null is an empty payload
+func splitBatchElement(item *batchItem, element json.RawMessage) []byte {
+	if !item.quoted && string(element) != "null" {
+		return element
+	}
+	return unwrapElement(element)
+}
+
+// unwrapElement returns the raw content of a json string element, and null as empty payload
+func unwrapElement(element json.RawMessage) []byte {
+	var s string
+	if err := json.Unmarshal(element, &s); err == nil {
+		return []byte(s)
+	}
+	if string(element) == "null" {
+		return []byte{}
+	}
+	return element
+}
@@ -0,0 +1,89 @@
+package function
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// cache a LRU cache whose entries expire after the ttl, it is bounded by both the number
+// of entries and the total bytes of values
+type cache struct {
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int
+	bytes      int
+	entries    map[string]*list.Element
+	lru        *list.List
+	lock       sync.Mutex
+}
+
+func newCache(ttl time.Duration, maxEntries, maxBytes int) *cache {
+	return &cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+// Get returns the value of the key if it exists and doesn't expire
+func (c *cache) Get(key string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return e.value, true
+}
+
+// Set stores the value, the least recently used entries are evicted if the cache is full,
+// the value is ignored if it is larger than the max bytes
+func (c *cache) Set(key string, value []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	if c.maxBytes > 0 && len(value) > c.maxBytes {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:     key,
+		value:   value,
+		expires: time.Now().Add(c.ttl),
+	})
+	c.bytes += len(value)
+	for (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Len returns the number of entries, including the expired ones not evicted yet
+func (c *cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len()
+}
+
+func (c *cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.bytes -= len(e.value)
+}
@@ -0,0 +1,49 @@
+package function
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	c := newCache(time.Hour, 2, 10)
+
+	c.Set("a", []byte("aaa"))
+	c.Set("b", []byte("bbb"))
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "aaa", string(v))
+
+	// b is the least recently used one
+	c.Set("c", []byte("ccc"))
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+
+	// evicted by bytes
+	c.Set("d", []byte("dddddddd"))
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	_, ok = c.Get("c")
+	assert.False(t, ok)
+	v, ok = c.Get("d")
+	assert.True(t, ok)
+	assert.Equal(t, "dddddddd", string(v))
+
+	// too large to be cached
+	c.Set("e", []byte("eeeeeeeeeee"))
+	_, ok = c.Get("e")
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Len())
+
+	c = newCache(time.Millisecond*10, 0, 0)
+	c.Set("a", []byte("aaa"))
+	_, ok = c.Get("a")
+	assert.True(t, ok)
+	time.Sleep(time.Millisecond * 20)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Len())
+}
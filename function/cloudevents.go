@@ -0,0 +1,161 @@
+package function
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	routing "github.com/qiangxue/fasthttp-routing"
+)
+
+// the attributes of cloudevents are put into the metadata of message with the prefix, as the headers of binary mode
+const cloudEventPrefix = "ce-"
+
+const (
+	cloudEventSpecVersion     = "1.0"
+	cloudEventContentType     = "application/cloudevents+json"
+	octetStreamContentType    = "application/octet-stream"
+	cloudEventAttrSpecVersion = "specversion"
+	cloudEventAttrContentType = "datacontenttype"
+	cloudEventAttrData        = "data"
+	cloudEventAttrDataBase64  = "data_base64"
+)
+
+// the modes of cloudevents http binding
+const (
+	cloudEventNone = iota
+	cloudEventBinary
+	cloudEventStructured
+)
+
+// parseCloudEvent puts the attributes of the cloudevent in the request into the metadata of message and
+// the data into the payload, the mode of the request is returned, which is none if it isn't a cloudevent
+func parseCloudEvent(c *routing.Context, message *baetyl.Message) (int, error) {
+	contentType := string(c.Request.Header.ContentType())
+	if strings.HasPrefix(strings.ToLower(contentType), cloudEventContentType) {
+		return cloudEventStructured, parseStructuredCloudEvent(c.PostBody(), message)
+	}
+	if len(c.Request.Header.Peek(cloudEventPrefix+cloudEventAttrSpecVersion)) == 0 {
+		return cloudEventNone, nil
+	}
+	c.Request.Header.VisitAll(func(k, v []byte) {
+		key := strings.ToLower(string(k))
+		if strings.HasPrefix(key, cloudEventPrefix) {
+			message.Metadata[key] = string(v)
+		}
+	})
+	if contentType != "" {
+		message.Metadata[cloudEventPrefix+cloudEventAttrContentType] = contentType
+	}
+	return cloudEventBinary, nil
+}
+
+func parseStructuredCloudEvent(body []byte, message *baetyl.Message) error {
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(body, &event); err != nil {
+		return errors.Errorf("invalid structured cloudevent: %s", err.Error())
+	}
+	if _, ok := event[cloudEventAttrSpecVersion]; !ok {
+		return errors.Errorf("invalid structured cloudevent: %s is missing", cloudEventAttrSpecVersion)
+	}
+	for k, v := range event {
+		if k == cloudEventAttrData || k == cloudEventAttrDataBase64 {
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			s = string(v) // the extensions may be numbers or booleans
+		}
+		message.Metadata[cloudEventPrefix+k] = s
+	}
+
+	message.Payload = nil
+	if v, ok := event[cloudEventAttrDataBase64]; ok {
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			return errors.Errorf("invalid structured cloudevent: %s", err.Error())
+		}
+		data, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return errors.Errorf("invalid structured cloudevent: %s", err.Error())
+		}
+		message.Payload = data
+		return nil
+	}
+	data, ok := event[cloudEventAttrData]
+	if !ok {
+		return nil
+	}
+	// the data which isn't json is carried as a string
+	var s string
+	if !isJSONContentType(message.Metadata[cloudEventPrefix+cloudEventAttrContentType]) && json.Unmarshal(data, &s) == nil {
+		message.Payload = []byte(s)
+		return nil
+	}
+	message.Payload = data
+	return nil
+}
+
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	return mediaType == jsonContentTypeHeader || strings.HasSuffix(mediaType, "+json")
+}
+
+// respondCloudEvent responds the payload of function as a cloudevent in the mode of the request,
+// which is binary mode if the request isn't a cloudevent, the id of the event is the invokeId
+func (a *API) respondCloudEvent(c *routing.Context, mode int, message *baetyl.Message, payload []byte) {
+	cfg := a.current().cfg.CloudEvents
+	source := cfg.Source
+	if source == "" {
+		source = "/" + message.Metadata["serviceName"]
+		if function := message.Metadata["functionName"]; function != "" {
+			source += "/" + function
+		}
+	}
+	contentType := octetStreamContentType
+	if json.Valid(payload) {
+		contentType = jsonContentTypeHeader
+	}
+	attrs := map[string]string{
+		cloudEventAttrSpecVersion: cloudEventSpecVersion,
+		"id":                      message.Metadata["invokeId"],
+		"source":                  source,
+		"type":                    cfg.Type,
+		"time":                    time.Now().UTC().Format(time.RFC3339Nano),
+		cloudEventAttrContentType: contentType,
+	}
+
+	if mode != cloudEventStructured {
+		for k, v := range attrs {
+			if k != cloudEventAttrContentType {
+				c.Response.Header.Set(cloudEventPrefix+k, v)
+			}
+		}
+		c.Response.SetStatusCode(http.StatusOK)
+		c.Response.SetBody(payload)
+		c.Response.Header.SetContentType(contentType)
+		return
+	}
+
+	event := map[string]interface{}{}
+	for k, v := range attrs {
+		event[k] = v
+	}
+	if contentType == jsonContentTypeHeader {
+		event[cloudEventAttrData] = json.RawMessage(bytes.TrimSpace(payload))
+	} else if len(payload) > 0 {
+		event[cloudEventAttrDataBase64] = base64.StdEncoding.EncodeToString(payload)
+	}
+	b, _ := json.Marshal(event)
+	c.Response.SetStatusCode(http.StatusOK)
+	c.Response.SetBody(b)
+	c.Response.Header.SetContentType(cloudEventContentType)
+}
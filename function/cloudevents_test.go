@@ -0,0 +1,120 @@
+package function
+
+import (
+	context2 "context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// cloudEventServer returns the payload and the cloudevent attributes it receives
+type cloudEventServer struct{}
+
+func (s *cloudEventServer) Call(ctx context2.Context, msg *baetyl.Message) (*baetyl.Message, error) {
+	b, _ := json.Marshal(map[string]string{
+		"payload": string(msg.Payload),
+		"id":      msg.Metadata["ce-id"],
+		"type":    msg.Metadata["ce-type"],
+		"source":  msg.Metadata["ce-source"],
+		"ext":     msg.Metadata["ce-ext"],
+	})
+	return &baetyl.Message{Payload: b, Metadata: msg.Metadata}, nil
+}
+
+func TestCloudEvents(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "cloudevents")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	s0 := mockGrpcServe(t, ports[0], serverCert, &cloudEventServer{})
+	defer s0.GracefulStop()
+
+	api := newMockAPI(t, newMockConfig(t), certPath, map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+	})
+	defer api.Close()
+
+	received := func(body []byte) map[string]string {
+		var r map[string]string
+		assert.NoError(t, json.Unmarshal(body, &r))
+		return r
+	}
+
+	// binary mode
+	resp := doRequest(api, http.MethodPost, "/serviceA/fn", []byte("raw"), map[string]string{
+		"ce-specversion": "1.0",
+		"ce-id":          "e1",
+		"ce-type":        "com.example.created",
+		"ce-source":      "/sensors/1",
+		"Content-Type":   "text/plain",
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, map[string]string{"payload": "raw", "id": "e1", "type": "com.example.created", "source": "/sensors/1", "ext": ""}, received(resp.Body()))
+
+	// structured mode
+	event := `{"specversion":"1.0","id":"e2","type":"com.example.updated","source":"/sensors/2","ext":1,"data":{"t":20}}`
+	resp = doRequest(api, http.MethodPost, "/serviceA/fn", []byte(event), map[string]string{"Content-Type": "application/cloudevents+json"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, map[string]string{"payload": `{"t":20}`, "id": "e2", "type": "com.example.updated", "source": "/sensors/2", "ext": "1"}, received(resp.Body()))
+
+	event = `{"specversion":"1.0","id":"e3","type":"t","source":"s","datacontenttype":"text/plain","data":"hello"}`
+	resp = doRequest(api, http.MethodPost, "/serviceA/fn", []byte(event), map[string]string{"Content-Type": "application/cloudevents+json"})
+	assert.Equal(t, "hello", received(resp.Body())["payload"])
+
+	event = `{"specversion":"1.0","id":"e4","type":"t","source":"s","data_base64":"aGVsbG8="}`
+	resp = doRequest(api, http.MethodPost, "/serviceA/fn", []byte(event), map[string]string{"Content-Type": "application/cloudevents+json"})
+	assert.Equal(t, "hello", received(resp.Body())["payload"])
+
+	resp = doRequest(api, http.MethodPost, "/serviceA/fn", []byte(`{"id":"e5"}`), map[string]string{"Content-Type": "application/cloudevents+json"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	// the responses are wrapped as cloudevents
+	cfg := newMockConfig(t)
+	cfg.CloudEvents.WrapResponse = true
+	assert.NoError(t, api.Reload(cfg))
+
+	resp = doRequest(api, http.MethodPost, "/serviceA/fn", []byte("raw"), map[string]string{"invokeid": "id-1"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "1.0", string(resp.Header.Peek("ce-specversion")))
+	assert.Equal(t, "id-1", string(resp.Header.Peek("ce-id")))
+	assert.Equal(t, "/serviceA/fn", string(resp.Header.Peek("ce-source")))
+	assert.Equal(t, "com.baetyl.function.response", string(resp.Header.Peek("ce-type")))
+	assert.Equal(t, "application/json", string(resp.Header.ContentType()))
+	assert.Equal(t, "raw", received(resp.Body())["payload"])
+
+	event = `{"specversion":"1.0","id":"e6","type":"t","source":"s","data":"x"}`
+	resp = doRequest(api, http.MethodPost, "/serviceA/fn", []byte(event), map[string]string{"Content-Type": "application/cloudevents+json", "invokeid": "id-2"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "application/cloudevents+json", string(resp.Header.ContentType()))
+	var wrapped struct {
+		SpecVersion string            `json:"specversion"`
+		ID          string            `json:"id"`
+		Source      string            `json:"source"`
+		Type        string            `json:"type"`
+		Data        map[string]string `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(resp.Body(), &wrapped))
+	assert.Equal(t, "1.0", wrapped.SpecVersion)
+	assert.Equal(t, "id-2", wrapped.ID)
+	assert.Equal(t, "/serviceA/fn", wrapped.Source)
+	assert.Equal(t, "com.baetyl.function.response", wrapped.Type)
+	assert.Equal(t, "e6", wrapped.Data["id"])
+}
@@ -4,12 +4,35 @@ import (
 	"time"
 
 	"github.com/baetyl/baetyl-go/v2/http"
+
+	"github.com/baetyl/baetyl-function/v2/deadletter"
+	"github.com/baetyl/baetyl-function/v2/js"
+	"github.com/baetyl/baetyl-function/v2/queue"
 )
 
 // Config
 type Config struct {
-	Server http.ServerConfig `yaml:"server" json:"server"`
-	Client ClientConfig      `yaml:"client" json:"client"`
+	Server      http.ServerConfig  `yaml:"server" json:"server"`
+	Admin       AdminConfig        `yaml:"admin" json:"admin"`
+	Client      ClientConfig       `yaml:"client" json:"client"`
+	GrpcServer  GrpcServerConfig   `yaml:"grpcserver" json:"grpcserver"`
+	Fanout      FanoutConfig       `yaml:"fanout" json:"fanout"`
+	WebSocket   WebSocketConfig    `yaml:"websocket" json:"websocket"`
+	DeadLetter  deadletter.Config  `yaml:"deadletter" json:"deadletter"`
+	Queue       queue.Config       `yaml:"queue" json:"queue"`
+	Idempotency IdempotencyConfig  `yaml:"idempotency" json:"idempotency"`
+	Shutdown    ShutdownConfig     `yaml:"shutdown" json:"shutdown"`
+	AccessLog   AccessLogConfig    `yaml:"accesslog" json:"accesslog"`
+	Errors      ErrorsConfig       `yaml:"errors" json:"errors"`
+	CloudEvents CloudEventsConfig  `yaml:"cloudevents" json:"cloudevents"`
+	Functions   []FunctionConfig   `yaml:"functions" json:"functions"`
+	Scripts     []js.ServiceConfig `yaml:"scripts" json:"scripts"`
+}
+
+// AdminConfig the admin endpoints are served on a separate listener, which is bound to the loopback by default
+// so that they aren't exposed to the callers of functions
+type AdminConfig struct {
+	Address string `yaml:"address" json:"address" default:"127.0.0.1:50013"`
 }
 
 type ClientConfig struct {
@@ -20,4 +43,89 @@ type GrpcConfig struct {
 	Port    int           `yaml:"port" json:"port" default:"80"`
 	Timeout time.Duration `yaml:"timeout" json:"timeout" default:"5m"`
 	Retries int           `yaml:"retries" json:"retries" default:"3"`
+	// Backoff the interval before the first retry, which doubles for every following one
+	Backoff time.Duration `yaml:"backoff" json:"backoff" default:"100ms"`
+}
+
+// GrpcServerConfig the grpc server implements the same Function.Call as the runtimes, and listens with
+// mtls of the system certificate if enabled
+type GrpcServerConfig struct {
+	Enable  bool   `yaml:"enable" json:"enable"`
+	Address string `yaml:"address" json:"address" default:":50012"`
+}
+
+type FanoutConfig struct {
+	MaxTargets int `yaml:"maxTargets" json:"maxTargets" default:"16"`
+}
+
+// WebSocketConfig the requests on a websocket connection are invoked concurrently up to the max concurrency,
+// and the following ones wait
+type WebSocketConfig struct {
+	Enable         bool  `yaml:"enable" json:"enable"`
+	MaxConcurrency int   `yaml:"maxConcurrency" json:"maxConcurrency" default:"16" validate:"min=1"`
+	MaxMessageSize int64 `yaml:"maxMessageSize" json:"maxMessageSize" default:"4194304"`
+}
+
+// ShutdownConfig the invocations in flight are waited for up to the drain timeout when shutting down
+type ShutdownConfig struct {
+	DrainTimeout time.Duration `yaml:"drainTimeout" json:"drainTimeout" default:"30s"`
+}
+
+// AccessLogConfig one json line is written for each request to the file or stdout if the path is empty,
+// the successful requests are sampled by the rate and all fields are written if none is specified
+type AccessLogConfig struct {
+	Enable     bool     `yaml:"enable" json:"enable"`
+	Path       string   `yaml:"path" json:"path"`
+	SampleRate float64  `yaml:"sampleRate" json:"sampleRate" default:"1" validate:"min=0,max=1"`
+	Fields     []string `yaml:"fields" json:"fields"`
+}
+
+// ErrorsConfig the internal details of failed invocations, such as the error message, the attempts, the backend
+// and the grpc code, are hidden from the callers except the trusted ones if hideDetails is enabled, the callers
+// are identified by the common names of their certificates, or the remote ips if there isn't one
+type ErrorsConfig struct {
+	HideDetails    bool     `yaml:"hideDetails" json:"hideDetails"`
+	TrustedCallers []string `yaml:"trustedCallers" json:"trustedCallers"`
+}
+
+// CloudEventsConfig the responses of functions are wrapped as cloudevents if enabled, the source is
+// '/<service>/<function>' if not specified
+type CloudEventsConfig struct {
+	WrapResponse bool   `yaml:"wrapResponse" json:"wrapResponse"`
+	Source       string `yaml:"source" json:"source"`
+	Type         string `yaml:"type" json:"type" default:"com.baetyl.function.response"`
+}
+
+// IdempotencyConfig the responses of the invocations are cached by invokeId within the window
+type IdempotencyConfig struct {
+	Enable     bool          `yaml:"enable" json:"enable"`
+	Window     time.Duration `yaml:"window" json:"window" default:"10m"`
+	MaxEntries int           `yaml:"maxEntries" json:"maxEntries" default:"10000"`
+	MaxBytes   int           `yaml:"maxBytes" json:"maxBytes" default:"67108864"`
+}
+
+// FunctionConfig the policies of a function, the policies of a service apply to all its
+// functions if the function is not specified
+type FunctionConfig struct {
+	Service  string        `yaml:"service" json:"service" validate:"nonzero"`
+	Function string        `yaml:"function" json:"function"`
+	Timeout  time.Duration `yaml:"timeout" json:"timeout"`
+	Cache    CacheConfig   `yaml:"cache" json:"cache"`
+	Coalesce bool          `yaml:"coalesce" json:"coalesce"`
+	Batch    BatchConfig   `yaml:"batch" json:"batch"`
+}
+
+// CacheConfig the responses are cached by the hash of payload
+type CacheConfig struct {
+	Enable       bool          `yaml:"enable" json:"enable"`
+	TTL          time.Duration `yaml:"ttl" json:"ttl" default:"1m"`
+	MaxEntries   int           `yaml:"maxEntries" json:"maxEntries" default:"1000"`
+	MaxEntrySize int           `yaml:"maxEntrySize" json:"maxEntrySize" default:"65536"`
+}
+
+// BatchConfig the invocations are sent in one batch for up to max size messages or the window
+type BatchConfig struct {
+	Enable  bool          `yaml:"enable" json:"enable"`
+	MaxSize int           `yaml:"maxSize" json:"maxSize" default:"16" validate:"min=1"`
+	Window  time.Duration `yaml:"window" json:"window" default:"10ms"`
 }
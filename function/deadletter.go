@@ -0,0 +1,115 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/docker/distribution/uuid"
+	routing "github.com/qiangxue/fasthttp-routing"
+
+	"github.com/baetyl/baetyl-function/v2/deadletter"
+)
+
+func (a *API) deadLetterEndpoints() []Endpoint {
+	if a.deadLetter == nil {
+		return nil
+	}
+	return []Endpoint{
+		{
+			Methods: []string{http.MethodGet},
+			Route:   "/_admin/deadletters",
+			Handler: a.onListDeadLetters,
+		},
+		{
+			Methods: []string{http.MethodPost},
+			Route:   "/_admin/deadletters/<id>/replay",
+			Handler: a.onReplayDeadLetter,
+		},
+		{
+			Methods: []string{http.MethodDelete},
+			Route:   "/_admin/deadletters/<id>",
+			Handler: a.onDeleteDeadLetter,
+		},
+	}
+}
+
+// putDeadLetter records the message which failed after all attempts, the ones failed for good at once,
+// such as bad inputs or missing functions, are left to the caller since replaying them won't help
+func (a *API) putDeadLetter(message *baetyl.Message, ierr *invokeError) {
+	if a.deadLetter == nil || !ierr.exhausted {
+		return
+	}
+	entry := &deadletter.Entry{
+		ID:        uuid.Generate().String(),
+		Message:   *message,
+		ErrCode:   ierr.errCode,
+		Error:     ierr.err.Error(),
+		Attempts:  ierr.attempts,
+		Timestamp: time.Now().UTC(),
+	}
+	if err := a.deadLetter.Put(entry); err != nil {
+		a.log.Warn("failed to put message into dead-letter sink", log.Any("invokeId", message.Metadata["invokeId"]), log.Error(err))
+		return
+	}
+	a.log.Debug("message is dead-lettered", log.Any("id", entry.ID), log.Any("invokeId", message.Metadata["invokeId"]))
+}
+
+func (a *API) onListDeadLetters(c *routing.Context) error {
+	entries, err := a.deadLetter.List()
+	if err != nil {
+		respondDeadLetterError(c, err)
+		return nil
+	}
+	b, _ := json.Marshal(entries)
+	respond(c, http.StatusOK, b)
+	return nil
+}
+
+// onReplayDeadLetter calls the dead-lettered message again as a new request does, and removes it from the sink if succeeded
+func (a *API) onReplayDeadLetter(c *routing.Context) error {
+	id := c.Param("id")
+	entry, err := a.deadLetter.Get(id)
+	if err != nil {
+		respondDeadLetterError(c, err)
+		return nil
+	}
+
+	a.log.Info("proxy replays a dead-lettered message", log.Any("id", id))
+
+	resp, ierr := a.call(context.Background(), &entry.Message, false)
+	if ierr != nil {
+		a.respondInvokeError(c, &entry.Message, ierr)
+		return nil
+	}
+	if err := a.deadLetter.Delete(id); err != nil {
+		a.log.Warn("failed to delete replayed message from dead-letter sink", log.Any("id", id), log.Error(err))
+	}
+	respond(c, http.StatusOK, resp.Payload)
+	return nil
+}
+
+func (a *API) onDeleteDeadLetter(c *routing.Context) error {
+	err := a.deadLetter.Delete(c.Param("id"))
+	if err != nil {
+		respondDeadLetterError(c, err)
+		return nil
+	}
+	respond(c, http.StatusOK, nil)
+	return nil
+}
+
+func respondDeadLetterError(c *routing.Context, err error) {
+	switch errors.Cause(err) {
+	case deadletter.ErrNotFound:
+		respondError(c, 404, "ERR_DEADLETTER_NOT_FOUND", err.Error())
+	case deadletter.ErrNotSupported:
+		respondError(c, 501, "ERR_DEADLETTER_NOT_SUPPORTED", err.Error())
+	default:
+		respondError(c, 500, "ERR_DEADLETTER", err.Error())
+	}
+}
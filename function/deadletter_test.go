@@ -0,0 +1,96 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl-function/v2/deadletter"
+)
+
+func TestDeadLetter(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "deadletter")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(2)
+	assert.NoError(t, err)
+	s0 := mockGrpc(t, ports[0], serverCert)
+	defer s0.GracefulStop()
+
+	cfg := newMockConfig(t)
+	cfg.DeadLetter.Sink = "spool"
+	cfg.DeadLetter.Path = path.Join(certPath, "spool")
+	// nothing listens on the second port, so the calls to serviceB fail after all attempts
+	addresses := map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+		"serviceB": fmt.Sprintf("127.0.0.1:%d", ports[1]),
+	}
+	api := newMockAPI(t, cfg, certPath, addresses)
+	defer api.Close()
+
+	resp := doRequest(api, http.MethodPost, "/serviceA", []byte("payload"), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+	// the failures which won't be fixed by retrying aren't dead-lettered
+	resp = doRequest(api, http.MethodPost, "/serviceA", []byte("error"), map[string]string{"invokeid": "invoke-1"})
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode())
+	resp = doRequest(api, http.MethodPost, "/serviceC/fn", []byte("payload"), map[string]string{"invokeid": "invoke-2"})
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+	resp = doRequest(api, http.MethodPost, "/serviceB/fn", []byte("payload"), map[string]string{"invokeid": "invoke-3"})
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode())
+	resp = doRequest(api, http.MethodPost, "/serviceB/fn", []byte("payload"), map[string]string{"invokeid": "invoke-4"})
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode())
+
+	// the admin endpoints aren't served on the port of functions
+	resp = doRequest(api, http.MethodGet, "/_admin/deadletters", nil, nil)
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode())
+
+	resp = doAdminRequest(api, http.MethodGet, "/_admin/deadletters", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	var entries []*deadletter.Entry
+	err = json.Unmarshal(resp.Body(), &entries)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "invoke-3", entries[0].Message.Metadata["invokeId"])
+	assert.Equal(t, "serviceB", entries[0].Message.Metadata["serviceName"])
+	assert.Equal(t, "fn", entries[0].Message.Metadata["functionName"])
+	assert.Equal(t, "ERR_FUNCTION_UNAVAILABLE", entries[0].ErrCode)
+	assert.Equal(t, cfg.Client.Grpc.Retries, entries[0].Attempts)
+	assert.Equal(t, "invoke-4", entries[1].Message.Metadata["invokeId"])
+
+	// replay fails while the service is still unavailable
+	resp = doAdminRequest(api, http.MethodPost, "/_admin/deadletters/"+entries[0].ID+"/replay", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode())
+
+	addresses["serviceB"] = fmt.Sprintf("127.0.0.1:%d", ports[0])
+	resp = doAdminRequest(api, http.MethodPost, "/_admin/deadletters/"+entries[0].ID+"/replay", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, fmt.Sprintf("{\"port\":%d}", ports[0]), string(resp.Body()))
+
+	resp = doAdminRequest(api, http.MethodPost, "/_admin/deadletters/"+entries[0].ID+"/replay", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+	resp = doAdminRequest(api, http.MethodDelete, "/_admin/deadletters/"+entries[1].ID, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+	resp = doAdminRequest(api, http.MethodGet, "/_admin/deadletters", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "[]", string(resp.Body()))
+}
@@ -0,0 +1,68 @@
+package function
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	routing "github.com/qiangxue/fasthttp-routing"
+)
+
+const headerTimeout = "X-Baetyl-Timeout"
+
+// metadataDeadline the metadata key of the deadline of an attempt in unix milliseconds,
+// by which the runtimes tell functions the remaining time
+const metadataDeadline = "deadline"
+
+// getTimeout returns the timeout of the request set by the caller, which is a duration such as '500ms',
+// or a number of milliseconds. Zero is returned if it is not set
+func getTimeout(c *routing.Context) (time.Duration, error) {
+	v := string(c.RequestCtx.Request.Header.Peek(headerTimeout))
+	if v == "" {
+		return 0, nil
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, errors.Errorf("invalid timeout (%s)", v)
+	}
+	return d, nil
+}
+
+// timeoutOf returns the timeout of each attempt to invoke the message, the timeout of the function
+// overrides the one of the grpc client
+func (a *API) timeoutOf(message *baetyl.Message) time.Duration {
+	if p := a.policyOf(message); p != nil && p.cfg.Timeout > 0 {
+		return p.cfg.Timeout
+	}
+	return a.current().cfg.Client.Grpc.Timeout
+}
+
+// budgetOf returns the time to invoke the message with all the attempts and the backoff between them,
+// which bounds the call shared by the callers
+func (a *API) budgetOf(message *baetyl.Message) time.Duration {
+	cfg := a.current().cfg.Client.Grpc
+	timeout := a.timeoutOf(message)
+	budget := timeout
+	for i := 1; i < cfg.Retries; i++ {
+		budget += cfg.Backoff<<uint(i-1) + timeout
+	}
+	return budget
+}
+
+// withDeadline returns a copy of the message whose metadata carries the deadline
+func withDeadline(message *baetyl.Message, deadline time.Time) *baetyl.Message {
+	metadata := make(map[string]string, len(message.Metadata)+1)
+	for k, v := range message.Metadata {
+		metadata[k] = v
+	}
+	metadata[metadataDeadline] = strconv.FormatInt(deadline.UnixNano()/int64(time.Millisecond), 10)
+	return &baetyl.Message{
+		ID:       message.ID,
+		Metadata: metadata,
+		Payload:  message.Payload,
+	}
+}
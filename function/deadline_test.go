@@ -0,0 +1,100 @@
+package function
+
+import (
+	context2 "context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// deadlineServer records the deadline passed in metadata
+type deadlineServer struct {
+	mockGrpcServer
+	deadline int64
+	lock     sync.Mutex
+}
+
+func (s *deadlineServer) Call(ctx context2.Context, msg *baetyl.Message) (*baetyl.Message, error) {
+	deadline, _ := strconv.ParseInt(msg.Metadata[metadataDeadline], 10, 64)
+	s.lock.Lock()
+	s.deadline = deadline
+	s.lock.Unlock()
+	return s.mockGrpcServer.Call(ctx, msg)
+}
+
+func TestDeadline(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "deadline")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	srv := &deadlineServer{mockGrpcServer: mockGrpcServer{port: ports[0], delay: 300 * time.Millisecond}}
+	s0 := mockGrpcServe(t, ports[0], serverCert, srv)
+	defer s0.GracefulStop()
+
+	cfg := newMockConfig(t)
+	cfg.Functions = []FunctionConfig{{Service: "serviceA", Function: "short", Timeout: 100 * time.Millisecond}}
+	api := newMockAPI(t, cfg, certPath, map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+	})
+	defer api.Close()
+
+	tests := []struct {
+		name     string
+		uri      string
+		timeout  string
+		code     int
+		deadline time.Duration
+	}{
+		{name: "client timeout", uri: "/serviceA/fn", code: http.StatusOK, deadline: 2 * time.Second},
+		{name: "function timeout", uri: "/serviceA/short", code: http.StatusGatewayTimeout, deadline: 100 * time.Millisecond},
+		{name: "caller timeout", uri: "/serviceA/fn", timeout: "150ms", code: http.StatusGatewayTimeout, deadline: 150 * time.Millisecond},
+		{name: "caller timeout in milliseconds", uri: "/serviceA/fn", timeout: "1000", code: http.StatusOK, deadline: time.Second},
+		{name: "caller timeout longer than function timeout", uri: "/serviceA/short", timeout: "1s", code: http.StatusGatewayTimeout, deadline: 100 * time.Millisecond},
+		{name: "invalid caller timeout", uri: "/serviceA/fn", timeout: "soon", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.lock.Lock()
+			srv.deadline = 0
+			srv.lock.Unlock()
+
+			start := time.Now()
+			resp := doRequest(api, http.MethodPost, tt.uri, []byte("payload"), map[string]string{headerTimeout: tt.timeout})
+			assert.Equal(t, tt.code, resp.StatusCode())
+			if tt.code == http.StatusBadRequest {
+				return
+			}
+
+			srv.lock.Lock()
+			deadline := time.Unix(0, srv.deadline*int64(time.Millisecond))
+			srv.lock.Unlock()
+			assert.WithinDuration(t, start.Add(tt.deadline), deadline, 50*time.Millisecond)
+		})
+	}
+
+	// the shared call is bounded by all the attempts and the backoff between them
+	short := &baetyl.Message{Metadata: map[string]string{"serviceName": "serviceA", "functionName": "short"}}
+	cfg.Client.Grpc.Retries, cfg.Client.Grpc.Backoff = 3, 50*time.Millisecond
+	assert.NoError(t, api.Reload(cfg))
+	assert.Equal(t, 3*100*time.Millisecond+(50+100)*time.Millisecond, api.budgetOf(short))
+}
@@ -0,0 +1,57 @@
+package function
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	routing "github.com/qiangxue/fasthttp-routing"
+)
+
+// how often the connection of a request in flight is checked
+const disconnectInterval = 100 * time.Millisecond
+
+// withDisconnect returns a context which is cancelled once the client of the request disconnects,
+// the connection is checked without consuming any data from it
+func (a *API) withDisconnect(ctx context.Context, c *routing.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	rc := rawConnOf(c.Conn())
+	if rc == nil {
+		return ctx, cancel
+	}
+	go func() {
+		ticker := time.NewTicker(disconnectInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if peerClosed(rc) {
+					a.log.Debug("client disconnected, the invocation is cancelled", log.Any("remote", c.RemoteAddr().String()))
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
+// rawConnOf returns the raw connection under the tls connection if there is one
+func rawConnOf(conn net.Conn) syscall.RawConn {
+	if tc, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = tc.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	return rc
+}
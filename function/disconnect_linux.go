@@ -0,0 +1,19 @@
+package function
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerClosed polls the connection for hang-up, which is reported even if there are unread data
+// such as the close notify alert of tls
+func peerClosed(rc syscall.RawConn) bool {
+	closed := false
+	err := rc.Control(func(fd uintptr) {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLRDHUP}}
+		n, err := unix.Poll(fds, 0)
+		closed = err == nil && n > 0 && fds[0].Revents&(unix.POLLRDHUP|unix.POLLHUP|unix.POLLERR) != 0
+	})
+	return err == nil && closed
+}
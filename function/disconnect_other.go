@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package function
+
+import "syscall"
+
+// peerClosed is not supported on this platform, the invocation runs until it completes
+func peerClosed(rc syscall.RawConn) bool {
+	return false
+}
@@ -0,0 +1,62 @@
+package function
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientDisconnect(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "disconnect")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	s0 := mockGrpcServe(t, ports[0], serverCert, &mockGrpcServer{port: ports[0], delay: 3 * time.Second})
+	defer s0.GracefulStop()
+
+	api := newMockAPI(t, newMockConfig(t), certPath, map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+	})
+	defer api.Close()
+	waitServer(t, "localhost:50011")
+
+	tlsConfig, err := utils.NewTLSConfigClient(utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "clientKey.pem"),
+		Cert: path.Join(certPath, "clientCrt.pem"),
+	})
+	assert.NoError(t, err)
+	// the server certificate of mock api has no names
+	tlsConfig.InsecureSkipVerify = true
+	conn, err := tls.Dial("tcp", "localhost:50011", tlsConfig)
+	assert.NoError(t, err)
+	_, err = conn.Write([]byte("POST /serviceA HTTP/1.1\r\nHost: localhost\r\nContent-Length: 7\r\n\r\npayload"))
+	assert.NoError(t, err)
+
+	for api.Inflight() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	start := time.Now()
+	conn.Close()
+	for api.Inflight() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, time.Since(start) < time.Second)
+}
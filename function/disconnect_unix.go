@@ -0,0 +1,22 @@
+//go:build darwin || freebsd || netbsd || openbsd
+// +build darwin freebsd netbsd openbsd
+
+package function
+
+import "syscall"
+
+// peerClosed peeks the connection, it is closed by the peer if the read returns nothing or fails.
+// The hang-up of a tls connection is not noticed until the close notify alert is read
+func peerClosed(rc syscall.RawConn) bool {
+	closed := false
+	buf := make([]byte, 1)
+	err := rc.Control(func(fd uintptr) {
+		n, _, err := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		if err == nil {
+			closed = n == 0
+			return
+		}
+		closed = err != syscall.EAGAIN && err != syscall.EWOULDBLOCK && err != syscall.EINTR
+	})
+	return err == nil && closed
+}
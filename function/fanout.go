@@ -0,0 +1,198 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/log"
+	routing "github.com/qiangxue/fasthttp-routing"
+)
+
+// completion modes of fan-out invocation
+const (
+	FanoutModeAll    = "all"
+	FanoutModeAny    = "any"
+	FanoutModeQuorum = "quorum"
+)
+
+// FanoutResult the result of one target of a fan-out invocation
+type FanoutResult struct {
+	Payload interface{}    `json:"payload,omitempty"`
+	Error   *ErrorResponse `json:"error,omitempty"`
+}
+
+type fanoutTarget struct {
+	service  string
+	function string
+}
+
+func (t fanoutTarget) String() string {
+	if t.function == "" {
+		return t.service
+	}
+	return t.service + "/" + t.function
+}
+
+type fanoutOutcome struct {
+	target  fanoutTarget
+	message *baetyl.Message
+	resp    *baetyl.Message
+	err     *invokeError
+}
+
+// fanoutEndpoints accepts POST and PUT as the function routes do, since the body is forwarded to every target as is
+func (a *API) fanoutEndpoints() []Endpoint {
+	return []Endpoint{
+		{
+			Methods: []string{http.MethodPost, http.MethodPut},
+			Route:   "/_fanout",
+			Handler: a.onFanoutMessage,
+		},
+	}
+}
+
+// onFanoutMessage sends the request body to all targets concurrently and aggregates the results,
+// the targets are given by query 'targets' as a comma-separated list of 'service' or 'service/function'
+func (a *API) onFanoutMessage(c *routing.Context) error {
+	args := c.QueryArgs()
+	targets := parseFanoutTargets(string(args.Peek("targets")))
+	if len(targets) == 0 {
+		respondError(c, 400, "ERR_FANOUT_TARGETS", "no fan-out targets are specified")
+		return nil
+	}
+	cfg := a.current().cfg
+	if len(targets) > cfg.Fanout.MaxTargets {
+		respondError(c, 400, "ERR_FANOUT_TARGETS", "too many fan-out targets, the limit is "+strconv.Itoa(cfg.Fanout.MaxTargets))
+		return nil
+	}
+
+	need, err := fanoutQuorum(string(args.Peek("mode")), string(args.Peek("quorum")), len(targets))
+	if err != nil {
+		respondError(c, 400, "ERR_FANOUT_MODE", err.Error())
+		return nil
+	}
+
+	timeout := cfg.Client.Grpc.Timeout
+	if v := args.Peek("timeout"); len(v) > 0 {
+		timeout, err = time.ParseDuration(string(v))
+		if err != nil || timeout <= 0 {
+			respondError(c, 400, "ERR_FANOUT_TIMEOUT", "invalid fan-out timeout: "+string(v))
+			return nil
+		}
+	}
+
+	a.log.Info("proxy received a fan-out request", log.Any("targets", len(targets)), log.Any("quorum", need))
+
+	// all calls share one deadline, and the remaining calls are cancelled once the mode is satisfied
+	// or can no longer be satisfied
+	ctx, cancel := a.withDisconnect(context.Background(), c)
+	defer cancel()
+	ctx, cancel = context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	invokeId, provided := getInvokeId(c)
+	c.Response.Header.Set(headerInvokeId, invokeId)
+	body := c.PostBody()
+	outcomes := make(chan fanoutOutcome, len(targets))
+	for _, t := range targets {
+		go func(t fanoutTarget) {
+			message := newMessage(t.service, t.function, invokeId, body)
+			resp, ierr := a.call(ctx, &message, provided)
+			outcomes <- fanoutOutcome{target: t, message: &message, resp: resp, err: ierr}
+		}(t)
+	}
+
+	hidden := a.hidesDetails(c)
+	results := map[string]*FanoutResult{}
+	succeeded, failed := 0, 0
+	settled := ""
+	for range targets {
+		o := <-outcomes
+		switch {
+		case o.err == nil:
+			succeeded++
+			results[o.target.String()] = &FanoutResult{Payload: fanoutPayload(o.resp.Payload)}
+		case settled != "":
+			resp := NewErrorResponse("ERR_FANOUT_CANCELLED", settled)
+			results[o.target.String()] = &FanoutResult{Error: &resp}
+		default:
+			failed++
+			resp := newInvokeErrorResponse(o.message, o.err, hidden)
+			results[o.target.String()] = &FanoutResult{Error: &resp}
+		}
+		if settled != "" {
+			continue
+		}
+		if succeeded >= need {
+			settled = "the fan-out completed before the target returned"
+			cancel()
+		} else if failed > len(targets)-need {
+			settled = "the fan-out failed before the target returned"
+			cancel()
+		}
+	}
+
+	code := http.StatusOK
+	if succeeded < need {
+		code = http.StatusInternalServerError
+	}
+	c.Response.Header.Set(headerServerTiming, fmt.Sprintf("total;dur=%.3f", milliseconds(time.Since(start))))
+	b, _ := json.Marshal(results)
+	respond(c, code, b)
+	return nil
+}
+
+func parseFanoutTargets(s string) []fanoutTarget {
+	var targets []fanoutTarget
+	seen := map[string]bool{}
+	for _, v := range strings.Split(s, ",") {
+		v = strings.Trim(strings.TrimSpace(v), "/")
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		parts := strings.SplitN(v, "/", 2)
+		t := fanoutTarget{service: parts[0]}
+		if len(parts) > 1 {
+			t.function = parts[1]
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// fanoutQuorum returns how many targets must succeed for the given mode
+func fanoutQuorum(mode, quorum string, total int) (int, error) {
+	switch mode {
+	case "", FanoutModeAll:
+		return total, nil
+	case FanoutModeAny:
+		return 1, nil
+	case FanoutModeQuorum:
+		if quorum == "" {
+			return total/2 + 1, nil
+		}
+		n, err := strconv.Atoi(quorum)
+		if err != nil || n < 1 || n > total {
+			return 0, errors.Errorf("invalid quorum (%s) for %d targets", quorum, total)
+		}
+		return n, nil
+	default:
+		return 0, errors.Errorf("unknown fan-out mode (%s)", mode)
+	}
+}
+
+func fanoutPayload(payload []byte) interface{} {
+	if json.Valid(payload) {
+		return json.RawMessage(payload)
+	}
+	return string(payload)
+}
@@ -0,0 +1,142 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFanout(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "fanout")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(3)
+	assert.NoError(t, err)
+	s0 := mockGrpc(t, ports[0], serverCert)
+	defer s0.GracefulStop()
+	s1 := mockGrpc(t, ports[1], serverCert)
+	defer s1.GracefulStop()
+	s2 := mockGrpcServe(t, ports[2], serverCert, &mockGrpcServer{port: ports[2], delay: time.Second})
+	defer s2.GracefulStop()
+
+	api := newMockAPI(t, newMockConfig(t), certPath, map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+		"serviceB": fmt.Sprintf("127.0.0.1:%d", ports[1]),
+		"serviceC": fmt.Sprintf("127.0.0.1:%d", ports[2]),
+	})
+	defer api.Close()
+
+	tests := []struct {
+		name      string
+		uri       string
+		body      string
+		code      int
+		succeeded []string
+		failed    map[string]string
+	}{
+		{
+			name:      "all",
+			uri:       "/_fanout?targets=serviceA,serviceB/fn",
+			body:      "payload",
+			code:      http.StatusOK,
+			succeeded: []string{"serviceA", "serviceB/fn"},
+		},
+		{
+			name:   "all with failure",
+			uri:    "/_fanout?targets=serviceA,serviceX&mode=all",
+			body:   "payload",
+			code:   http.StatusInternalServerError,
+			failed: map[string]string{"serviceX": "ERR_ADDRESS_RESOLVE"},
+		},
+		{
+			name:   "all cancels slow target on failure",
+			uri:    "/_fanout?targets=serviceX,serviceC&mode=all",
+			body:   "payload",
+			code:   http.StatusInternalServerError,
+			failed: map[string]string{"serviceX": "ERR_ADDRESS_RESOLVE", "serviceC": "ERR_FANOUT_CANCELLED"},
+		},
+		{
+			name:      "any cancels slow target",
+			uri:       "/_fanout?targets=serviceA,serviceC&mode=any",
+			body:      "payload",
+			code:      http.StatusOK,
+			succeeded: []string{"serviceA"},
+			failed:    map[string]string{"serviceC": "ERR_FANOUT_CANCELLED"},
+		},
+		{
+			name:      "quorum",
+			uri:       "/_fanout?targets=serviceA,serviceB,serviceX&mode=quorum",
+			body:      "payload",
+			code:      http.StatusOK,
+			succeeded: []string{"serviceA", "serviceB"},
+			failed:    map[string]string{"serviceX": "ERR_ADDRESS_RESOLVE"},
+		},
+		{
+			name:   "shared deadline",
+			uri:    "/_fanout?targets=serviceC&timeout=100ms",
+			body:   "payload",
+			code:   http.StatusInternalServerError,
+			failed: map[string]string{"serviceC": "ERR_FUNCTION_TIMEOUT"},
+		},
+		{
+			name:   "function error",
+			uri:    "/_fanout?targets=serviceA,serviceB&mode=quorum&quorum=1",
+			body:   "error",
+			code:   http.StatusInternalServerError,
+			failed: map[string]string{"serviceA": "ERR_FUNCTION_CALL", "serviceB": "ERR_FUNCTION_CALL"},
+		},
+		{
+			name: "no targets",
+			uri:  "/_fanout",
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "invalid quorum",
+			uri:  "/_fanout?targets=serviceA&mode=quorum&quorum=2",
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "unknown mode",
+			uri:  "/_fanout?targets=serviceA&mode=some",
+			code: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(api, http.MethodPost, tt.uri, []byte(tt.body), nil)
+			assert.Equal(t, tt.code, resp.StatusCode())
+			if tt.code == http.StatusBadRequest {
+				return
+			}
+
+			results := map[string]FanoutResult{}
+			err := json.Unmarshal(resp.Body(), &results)
+			assert.NoError(t, err)
+			for _, target := range tt.succeeded {
+				assert.Nil(t, results[target].Error)
+				assert.NotNil(t, results[target].Payload)
+			}
+			for target, errCode := range tt.failed {
+				assert.NotNil(t, results[target].Error)
+				assert.Equal(t, errCode, results[target].Error.ErrCode)
+			}
+		})
+	}
+}
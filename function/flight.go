@@ -0,0 +1,76 @@
+package function
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+)
+
+type flightCall struct {
+	done chan struct{}
+	resp *baetyl.Message
+	err  *invokeError
+}
+
+// flightGroup runs only one call at a time for the same key, the callers arriving
+// during the call wait for it and share its result
+type flightGroup struct {
+	calls map[string]*flightCall
+	lock  sync.Mutex
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: map[string]*flightCall{}}
+}
+
+// Do runs fn for the key if no call of the key is in flight, otherwise waits for the
+// one in flight, shared reports whether the result comes from another caller. The call
+// isn't bound to any of the callers, fn gets a context detached from ctx which is bounded
+// by the timeout only, which covers all the attempts of the call, and every caller stops
+// waiting once its own ctx is done
+func (g *flightGroup) Do(ctx context.Context, key string, timeout time.Duration, fn func(ctx context.Context) (*baetyl.Message, *invokeError)) (resp *baetyl.Message, err *invokeError, shared bool) {
+	g.lock.Lock()
+	c, shared := g.calls[key]
+	if !shared {
+		c = &flightCall{done: make(chan struct{})}
+		g.calls[key] = c
+		go func() {
+			cctx, cancel := context.WithTimeout(detachedContext{ctx}, timeout)
+			defer cancel()
+			c.resp, c.err = fn(cctx)
+
+			g.lock.Lock()
+			delete(g.calls, key)
+			g.lock.Unlock()
+			close(c.done)
+		}()
+	}
+	g.lock.Unlock()
+
+	select {
+	case <-c.done:
+		return c.resp, c.err, shared
+	case <-ctx.Done():
+		return nil, callError(contextError(ctx), 0), shared
+	}
+}
+
+// detachedContext keeps the values of the parent, such as the trace of the invocation,
+// but not its deadline and cancellation
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
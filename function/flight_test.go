@@ -0,0 +1,60 @@
+package function
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlightGroup(t *testing.T) {
+	g := newFlightGroup()
+	started := make(chan struct{})
+	call := func(ctx context.Context) (*baetyl.Message, *invokeError) {
+		close(started)
+		select {
+		case <-time.After(100 * time.Millisecond):
+			return &baetyl.Message{Payload: []byte("done")}, nil
+		case <-ctx.Done():
+			return nil, callError(contextError(ctx), 1)
+		}
+	}
+
+	// the first caller gives up, the call goes on for the one waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, ierr, shared := g.Do(ctx, "k", time.Second, call)
+		assert.False(t, shared)
+		assert.NotNil(t, ierr)
+	}()
+	<-started
+	cancel()
+
+	resp, ierr, shared := g.Do(context.Background(), "k", time.Second, nil)
+	assert.Nil(t, ierr)
+	assert.True(t, shared)
+	assert.Equal(t, "done", string(resp.Payload))
+	wg.Wait()
+
+	// the waiter applies its own deadline
+	started = make(chan struct{})
+	go g.Do(context.Background(), "k", time.Second, call)
+	<-started
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, ierr, shared = g.Do(ctx, "k", time.Second, nil)
+	assert.True(t, shared)
+	assert.Equal(t, "ERR_FUNCTION_TIMEOUT", ierr.errCode)
+
+	// the call is bounded by the timeout
+	started = make(chan struct{})
+	_, ierr, shared = g.Do(context.Background(), "j", 10*time.Millisecond, call)
+	assert.False(t, shared)
+	assert.Equal(t, "ERR_FUNCTION_TIMEOUT", ierr.errCode)
+}
@@ -0,0 +1,155 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/baetyl/baetyl-function/v2/js"
+)
+
+// the backend reported for the services served by in-process handlers
+const localBackend = "local"
+
+// Handler the in-process function of a service, which follows the message contract of the runtimes: the
+// metadata carries serviceName, functionName, invokeId and deadline, the function is chosen by functionName,
+// and the structured error is reported by returning a *RuntimeError or a grpc status with the details.
+// The handler should return once ctx is done.
+type Handler func(ctx context.Context, message *baetyl.Message) (*baetyl.Message, error)
+
+// the handlers registered by Register, keyed by the service name
+var (
+	registered   = map[string]Handler{}
+	registerLock sync.Mutex
+)
+
+// Register registers the handler of the service served in process, it is called in init() of a custom binary.
+// The messages of the service are handled by it instead of resolving and dialing the runtimes, through the same
+// policies, retries and deadlines. It panics if the handler is nil or the service is registered twice.
+func Register(service string, h Handler) {
+	registerLock.Lock()
+	defer registerLock.Unlock()
+	if h == nil {
+		panic("function: the handler of service (" + service + ") is nil")
+	}
+	if _, ok := registered[service]; ok {
+		panic("function: the handler of service (" + service + ") is registered twice")
+	}
+	registered[service] = h
+}
+
+// newHandlers returns the registered handlers together with the handlers of the script services
+func newHandlers(scripts []js.ServiceConfig) (map[string]Handler, error) {
+	handlers := map[string]Handler{}
+	registerLock.Lock()
+	for name, handler := range registered {
+		handlers[name] = handler
+	}
+	registerLock.Unlock()
+	for _, sc := range scripts {
+		if _, ok := handlers[sc.Service]; ok {
+			return nil, errors.Errorf("duplicate handlers of service (%s)", sc.Service)
+		}
+		svc, err := js.NewService(sc)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		handlers[sc.Service] = svc.Call
+	}
+	return handlers, nil
+}
+
+// invokeLocal calls the in-process handler of the message's service with retries, the same as invoke
+func (a *API) invokeLocal(ctx context.Context, handler Handler, message *baetyl.Message) (*baetyl.Message, *invokeError) {
+	serviceName := message.Metadata["serviceName"]
+	functionName := message.Metadata["functionName"]
+	cfg := a.current().cfg.Client.Grpc
+	timeout := a.timeoutOf(message)
+	t := traceOf(ctx)
+	t.resolved(0, localBackend)
+
+	var last error
+	for i := 0; i < cfg.Retries; i++ {
+		if i > 0 && !waitRetry(ctx, cfg.Backoff, i) {
+			ierr := callError(contextError(ctx), i)
+			ierr.address = localBackend
+			return nil, ierr
+		}
+		cctx, cancel := context.WithTimeout(ctx, timeout)
+		deadline, _ := cctx.Deadline()
+
+		sent := time.Now()
+		resp, err := callHandler(cctx, handler, withDeadline(message, deadline))
+		t.attempted(sent, time.Since(sent))
+		cancel()
+		if err == nil {
+			a.log.Debug("call local function successfully", log.Any("service", serviceName), log.Any("function", functionName))
+			return resp, nil
+		}
+		last = err
+
+		if rerr := runtimeErrorOf(err); rerr != nil && rerr.Retryable && ctx.Err() == nil {
+			a.log.Debug("local function reports a retryable error", log.Any("retry", i+1), log.Any("reason", rerr.Reason), log.Error(err))
+			continue
+		}
+
+		a.log.Debug("call local function failed", log.Any("service", serviceName), log.Any("function", functionName), log.Error(err))
+		ierr := callError(err, i+1)
+		ierr.address = localBackend
+		return nil, ierr
+	}
+
+	ierr := callError(last, cfg.Retries)
+	ierr.address = localBackend
+	ierr.exhausted = true
+	ierr.err = errors.Errorf("failed to invoke local service %s after %v retries: %v", serviceName, cfg.Retries, last)
+	a.log.Debug("call local function failed", log.Any("service", serviceName), log.Any("function", functionName), log.Error(ierr.err))
+	return nil, ierr
+}
+
+// callHandler calls the handler in a goroutine, so that the call returns once ctx is done as a grpc call does,
+// the panic of the handler is reported as the error of user code
+func callHandler(ctx context.Context, handler Handler, message *baetyl.Message) (*baetyl.Message, error) {
+	type result struct {
+		resp *baetyl.Message
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{err: &RuntimeError{Reason: ReasonUserCode, Message: fmt.Sprintf("[UserCodeInvoke] panic: %v", r)}}
+			}
+		}()
+		resp, err := handler(ctx, message)
+		if err == nil && resp == nil {
+			resp = &baetyl.Message{Metadata: message.Metadata}
+		}
+		done <- result{resp: resp, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil && ctx.Err() != nil {
+			return nil, contextError(ctx)
+		}
+		return r.resp, r.err
+	case <-ctx.Done():
+		return nil, contextError(ctx)
+	}
+}
+
+// contextError returns the grpc status of the done context, as a grpc client does
+func contextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return status.Error(codes.DeadlineExceeded, ctx.Err().Error())
+	}
+	return status.Error(codes.Canceled, ctx.Err().Error())
+}
@@ -0,0 +1,158 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/baetyl/baetyl-function/v2/js"
+)
+
+func TestLocalHandler(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "handler")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	var calls int32
+	Register("local", func(ctx context.Context, msg *baetyl.Message) (*baetyl.Message, error) {
+		switch msg.Metadata["functionName"] {
+		case "upper":
+			msg.Payload = []byte(strings.ToUpper(string(msg.Payload)) + " " + msg.Metadata["serviceName"])
+			return msg, nil
+		case "deadline":
+			msg.Payload = []byte(msg.Metadata[metadataDeadline])
+			return msg, nil
+		case "retryable":
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return nil, &RuntimeError{Reason: ReasonUserCode, Message: "try again", Retryable: true}
+			}
+			return &baetyl.Message{Payload: []byte("retried")}, nil
+		case "bad":
+			return nil, &RuntimeError{Reason: ReasonBadInput, Message: "bad input"}
+		case "panic":
+			panic("oops")
+		case "block":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		default:
+			return nil, errors.New("failed")
+		}
+	})
+	defer unregister("local")
+
+	// a service is registered only once
+	assert.Panics(t, func() {
+		Register("local", func(ctx context.Context, msg *baetyl.Message) (*baetyl.Message, error) { return msg, nil })
+	})
+	assert.Panics(t, func() { Register("nil", nil) })
+
+	// the local service isn't resolved
+	api := newMockAPI(t, newMockConfig(t), certPath, map[string]string{})
+	defer api.Close()
+
+	resp := doRequest(api, http.MethodPost, "/local/upper", []byte("hello"), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "HELLO local", string(resp.Body()))
+	assert.Equal(t, localBackend, string(resp.Header.Peek(headerBackend)))
+
+	resp = doRequest(api, http.MethodPost, "/local/deadline", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.NotEmpty(t, string(resp.Body()))
+
+	resp = doRequest(api, http.MethodPost, "/local/retryable", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "retried", string(resp.Body()))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	errorOf := func(resp interface{ Body() []byte }) ErrorResponse {
+		var res ErrorResponse
+		assert.NoError(t, json.Unmarshal(resp.Body(), &res))
+		return res
+	}
+
+	resp = doRequest(api, http.MethodPost, "/local/bad", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	res := errorOf(resp)
+	assert.Equal(t, "ERR_FUNCTION_BAD_INPUT", res.ErrCode)
+	assert.Equal(t, "bad input", res.Message)
+	assert.Equal(t, localBackend, res.Backend)
+	assert.Equal(t, 1, res.Attempts)
+
+	resp = doRequest(api, http.MethodPost, "/local/panic", nil, nil)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode())
+	res = errorOf(resp)
+	assert.Equal(t, "ERR_FUNCTION_USER_CODE", res.ErrCode)
+	assert.Equal(t, "[UserCodeInvoke] panic: oops", res.Message)
+
+	resp = doRequest(api, http.MethodPost, "/local/block", nil, map[string]string{headerTimeout: "100ms"})
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode())
+	assert.Equal(t, "ERR_FUNCTION_TIMEOUT", errorOf(resp).ErrCode)
+
+	resp = doRequest(api, http.MethodPost, "/local/other", nil, nil)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode())
+	assert.Equal(t, "ERR_FUNCTION_CALL", errorOf(resp).ErrCode)
+
+	// the structured error is reported to the grpc callers in the details
+	rerr := &RuntimeError{Reason: ReasonBadInput, Message: "bad input"}
+	err = rerr.Status(codes.InvalidArgument)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, rerr, runtimeErrorOf(err))
+}
+
+func TestScriptHandler(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "script")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	codePath := t.TempDir()
+	src := `exports.handler = (event, context, callback) => callback(null, {hello: event, service: context.serviceName});`
+	assert.NoError(t, ioutil.WriteFile(path.Join(codePath, "index.js"), []byte(src), 0644))
+
+	cfg := newMockConfig(t)
+	cfg.Scripts = []js.ServiceConfig{{
+		Service:   "script",
+		CodePath:  codePath,
+		Functions: []js.FunctionConfig{{Name: "hello", Handler: "index.handler"}},
+	}}
+	api := newMockAPI(t, cfg, certPath, map[string]string{})
+	defer api.Close()
+
+	resp := doRequest(api, http.MethodPost, "/script/hello", []byte(`"world"`), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, `{"hello":"world","service":"script"}`, string(resp.Body()))
+	assert.Equal(t, localBackend, string(resp.Header.Peek(headerBackend)))
+
+	resp = doRequest(api, http.MethodPost, "/script/missing", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+	// the script services can't shadow the registered handlers
+	Register("script", func(ctx context.Context, msg *baetyl.Message) (*baetyl.Message, error) { return msg, nil })
+	defer unregister("script")
+	_, err = NewAPI(cfg, &mockContext{}, &mockResolver{})
+	assert.EqualError(t, err, "duplicate handlers of service (script)")
+}
+
+// unregister removes the handler registered by the test
+func unregister(service string) {
+	registerLock.Lock()
+	defer registerLock.Unlock()
+	delete(registered, service)
+}
@@ -0,0 +1,45 @@
+package function
+
+import (
+	"context"
+
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/log"
+)
+
+// idempotency makes the invocations with the same invokeId run only once within the window,
+// the later ones get the cached response or wait for the call in flight
+type idempotency struct {
+	responses *cache
+	flights   *flightGroup
+}
+
+func newIdempotency(cfg IdempotencyConfig) *idempotency {
+	return &idempotency{
+		responses: newCache(cfg.Window, cfg.MaxEntries, cfg.MaxBytes),
+		flights:   newFlightGroup(),
+	}
+}
+
+func idempotencyKey(message *baetyl.Message) string {
+	return message.Metadata["serviceName"] + "/" + message.Metadata["functionName"] + "/" + message.Metadata["invokeId"]
+}
+
+func (a *API) callIdempotent(ctx context.Context, message *baetyl.Message) (*baetyl.Message, *invokeError) {
+	key := idempotencyKey(message)
+	resp, ierr, shared := a.idempotency.flights.Do(ctx, key, a.budgetOf(message), func(ctx context.Context) (*baetyl.Message, *invokeError) {
+		if payload, ok := a.idempotency.responses.Get(key); ok {
+			a.log.Debug("response of the duplicated invocation is cached", log.Any("invokeId", message.Metadata["invokeId"]))
+			return &baetyl.Message{Metadata: message.Metadata, Payload: payload}, nil
+		}
+		resp, ierr := a.callFunction(ctx, message)
+		if ierr == nil {
+			a.idempotency.responses.Set(key, resp.Payload)
+		}
+		return resp, ierr
+	})
+	if shared {
+		a.log.Debug("duplicated invocation waited for the one in flight", log.Any("invokeId", message.Metadata["invokeId"]))
+	}
+	return resp, ierr
+}
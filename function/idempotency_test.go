@@ -0,0 +1,81 @@
+package function
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotency(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "idempotency")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	srv := &mockGrpcServer{port: ports[0], delay: 100 * time.Millisecond}
+	s0 := mockGrpcServe(t, ports[0], serverCert, srv)
+	defer s0.GracefulStop()
+
+	cfg := newMockConfig(t)
+	cfg.Idempotency.Enable = true
+	api := newMockAPI(t, cfg, certPath, map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+	})
+	defer api.Close()
+
+	// concurrent duplicated invocations wait for the one in flight
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := doRequest(api, http.MethodPost, "/serviceA/fn", []byte("payload"), map[string]string{"invokeid": "invoke-1"})
+			assert.Equal(t, http.StatusOK, resp.StatusCode())
+			assert.Equal(t, fmt.Sprintf("{\"port\":%d}", ports[0]), string(resp.Body()))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&srv.calls))
+
+	// the later one gets the cached response
+	resp := doRequest(api, http.MethodPost, "/serviceA/fn", []byte("payload"), map[string]string{"invokeid": "invoke-1"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&srv.calls))
+
+	// the same invokeId of another function is not a duplicate
+	resp = doRequest(api, http.MethodPost, "/serviceA", []byte("payload"), map[string]string{"invokeid": "invoke-1"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&srv.calls))
+
+	// invocations without invokeId are never deduplicated
+	resp = doRequest(api, http.MethodPost, "/serviceA/fn", []byte("payload"), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	resp = doRequest(api, http.MethodPost, "/serviceA/fn", []byte("payload"), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, int32(4), atomic.LoadInt32(&srv.calls))
+
+	// failed invocations are not cached
+	resp = doRequest(api, http.MethodPost, "/serviceA/fn", []byte("error"), map[string]string{"invokeid": "invoke-2"})
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode())
+	resp = doRequest(api, http.MethodPost, "/serviceA/fn", []byte("error"), map[string]string{"invokeid": "invoke-2"})
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode())
+	assert.Equal(t, int32(6), atomic.LoadInt32(&srv.calls))
+}
@@ -0,0 +1,138 @@
+package function
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcIngress implements the same Function.Call as the runtimes, so that the callers speaking grpc
+// invoke the functions through the proxy with the metadata kept
+type grpcIngress struct {
+	api *API
+	svr *grpc.Server
+	log *log.Logger
+}
+
+// newGrpcIngress listens on the address with mtls of the certificate, the clients must present
+// the certificates signed by the same ca
+func newGrpcIngress(api *API, cfg GrpcServerConfig, cert utils.Certificate) (*grpcIngress, error) {
+	cert.ClientAuthType = tls.RequireAndVerifyClientCert
+	tlsConfig, err := utils.NewTLSConfigServer(cert)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ln, err := net.Listen("tcp4", cfg.Address)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	g := &grpcIngress{
+		api: api,
+		svr: grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig))),
+		log: log.With(log.Any("function", "grpc")),
+	}
+	baetyl.RegisterFunctionServer(g.svr, g)
+	go func() {
+		g.log.Info("grpc server is running", log.Any("address", cfg.Address))
+		if err := g.svr.Serve(ln); err != nil {
+			g.log.Error("grpc server shutdown", log.Error(err))
+		}
+	}()
+	return g, nil
+}
+
+// Call routes the message to the backend by the metadata 'serviceName' and 'functionName' through the
+// policies, the metadata 'invokeId' is generated if not given
+func (g *grpcIngress) Call(ctx context.Context, msg *baetyl.Message) (*baetyl.Message, error) {
+	if g.api.Draining() {
+		return nil, status.Error(codes.Unavailable, "ERR_SHUTTING_DOWN: the proxy is shutting down")
+	}
+	serviceName := msg.Metadata["serviceName"]
+	if serviceName == "" {
+		return nil, status.Error(codes.InvalidArgument, "ERR_INVALID_MESSAGE: the metadata serviceName is missing")
+	}
+
+	g.log.Debug("proxy received a grpc request", log.Any("service", serviceName), log.Any("function", msg.Metadata["functionName"]))
+
+	message := baetyl.Message{ID: msg.ID, Payload: msg.Payload, Metadata: map[string]string{}}
+	for k, v := range msg.Metadata {
+		message.Metadata[k] = v
+	}
+	provided := message.Metadata["invokeId"] != ""
+	if !provided {
+		message.Metadata["invokeId"] = newInvokeId()
+	}
+
+	resp, ierr := g.api.call(ctx, &message, provided)
+	if ierr == nil {
+		// the response may be shared with other callers, so it is copied rather than modified
+		return &baetyl.Message{ID: msg.ID, Metadata: resp.Metadata, Payload: resp.Payload}, nil
+	}
+	if ctx.Err() != context.Canceled {
+		g.api.putDeadLetter(&message, ierr)
+	}
+	if g.api.hidesDetailsFrom(grpcCallerOf(ctx)) {
+		return nil, status.Error(grpcCodeOf(ierr.code), ierr.errCode+": "+hiddenErrorMessage)
+	}
+	// the status of the runtime is passed through with its details
+	if _, ok := status.FromError(ierr.err); ok {
+		return nil, ierr.err
+	}
+	if rerr, ok := ierr.err.(*RuntimeError); ok {
+		return nil, rerr.Status(grpcCodeOf(ierr.code))
+	}
+	return nil, status.Error(grpcCodeOf(ierr.code), ierr.errCode+": "+ierr.err.Error())
+}
+
+// Close stops the grpc server, the invocations in flight are cut off
+func (g *grpcIngress) Close() {
+	g.svr.Stop()
+}
+
+// grpcCallerOf identifies the caller as callerOf does, by the common name of its certificate or its ip
+func grpcCallerOf(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+		if cn := info.State.PeerCertificates[0].Subject.CommonName; cn != "" {
+			return cn
+		}
+	}
+	if addr, ok := p.Addr.(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return p.Addr.String()
+}
+
+func grpcCodeOf(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case 499:
+		return codes.Canceled
+	default:
+		return codes.Internal
+	}
+}
@@ -0,0 +1,93 @@
+package function
+
+import (
+	context2 "context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcIngress(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "ingress")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(2)
+	assert.NoError(t, err)
+	s0 := mockGrpcServe(t, ports[0], serverCert, &mockGrpcServer{port: ports[0]})
+	defer s0.GracefulStop()
+
+	cfg := newMockConfig(t)
+	cfg.GrpcServer.Enable = true
+	cfg.GrpcServer.Address = fmt.Sprintf(":%d", ports[1])
+	api := newMockAPI(t, cfg, certPath, map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+	})
+	defer api.Close()
+
+	// the certificate of the mock ca has no names to verify
+	dial := func(cert utils.Certificate) baetyl.FunctionClient {
+		cert.InsecureSkipVerify = true
+		tlsCfg, err := utils.NewTLSConfigClient(cert)
+		assert.NoError(t, err)
+		conn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%d", ports[1]), grpc.WithTransportCredentials(credentials.NewTLS(tlsCfg)))
+		assert.NoError(t, err)
+		return baetyl.NewFunctionClient(conn)
+	}
+	client := dial(serverCert)
+	ctx, cancel := context2.WithTimeout(context2.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := client.Call(ctx, &baetyl.Message{
+		ID:       7,
+		Payload:  []byte("payload"),
+		Metadata: map[string]string{"serviceName": "serviceA", "functionName": "fn", "custom": "value"},
+	})
+	assert.NoError(t, err)
+	var o map[string]int
+	assert.NoError(t, json.Unmarshal(resp.Payload, &o))
+	assert.Equal(t, ports[0], o["port"])
+	assert.Equal(t, "value", resp.Metadata["custom"])
+	assert.NotEmpty(t, resp.Metadata["invokeId"])
+	assert.Equal(t, uint64(7), resp.ID)
+
+	_, err = client.Call(ctx, &baetyl.Message{Payload: []byte("payload")})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Call(ctx, &baetyl.Message{Payload: []byte("payload"), Metadata: map[string]string{"serviceName": "serviceB"}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "serviceB")
+
+	// the details are hidden from the callers not trusted
+	hidden := cfg
+	hidden.Errors.HideDetails = true
+	assert.NoError(t, api.Reload(hidden))
+	_, err = client.Call(ctx, &baetyl.Message{Payload: []byte("payload"), Metadata: map[string]string{"serviceName": "serviceB"}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "ERR_ADDRESS_RESOLVE: "+hiddenErrorMessage, status.Convert(err).Message())
+
+	// the clients without certificate are rejected
+	client = dial(utils.Certificate{CA: serverCert.CA})
+	_, err = client.Call(ctx, &baetyl.Message{Payload: []byte("payload"), Metadata: map[string]string{"serviceName": "serviceA"}})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -42,10 +42,6 @@ func NewManager(cert utils.Certificate) (Manager, error) {
 
 // GetGRPCConnection returns a new grpc connection for a given address and inits one if doesn't exist
 func (g *manager) GetGRPCConnection(address string, recreateIfExists bool) (*grpc.ClientConn, error) {
-	if val, ok := g.connectionPool[address]; ok && !recreateIfExists {
-		return val, nil
-	}
-
 	g.lock.Lock()
 	defer g.lock.Unlock()
 	if val, ok := g.connectionPool[address]; ok && !recreateIfExists {
@@ -67,6 +63,8 @@ func (g *manager) GetGRPCConnection(address string, recreateIfExists bool) (*grp
 }
 
 func (g *manager) Close() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
 	for address, conn := range g.connectionPool {
 		err := conn.Close()
 		if err != nil {
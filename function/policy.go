@@ -0,0 +1,154 @@
+package function
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/log"
+)
+
+// policy the per-function policies applied to the invocations of a function
+type policy struct {
+	// keep the counters first to be 64-bit aligned on 32-bit platforms
+	cacheHits   uint64
+	cacheMisses uint64
+	coalesced   uint64
+
+	cfg     FunctionConfig
+	cache   *cache
+	flights *flightGroup
+	batcher *batcher
+}
+
+func (a *API) newPolicy(cfg FunctionConfig) *policy {
+	p := &policy{cfg: cfg}
+	if cfg.Cache.Enable {
+		p.cache = newCache(cfg.Cache.TTL, cfg.Cache.MaxEntries, 0)
+	}
+	if cfg.Coalesce {
+		p.flights = newFlightGroup()
+	}
+	if cfg.Batch.Enable {
+		p.batcher = newBatcher(cfg.Batch, a.invoke)
+	}
+	return p
+}
+
+func (p *policy) name() string {
+	return policyName(p.cfg)
+}
+
+func policyName(cfg FunctionConfig) string {
+	if cfg.Function == "" {
+		return cfg.Service
+	}
+	return cfg.Service + "/" + cfg.Function
+}
+
+// checkPolicies rejects the config with more than one policy of a function
+func checkPolicies(cfgs []FunctionConfig) error {
+	seen := map[string]bool{}
+	for _, cfg := range cfgs {
+		name := policyName(cfg)
+		if seen[name] {
+			return errors.Errorf("duplicate policies of function (%s)", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// newPolicies creates the policies of functions, the old policy of a function is kept with its
+// cache and counters if its config is not changed
+func (a *API) newPolicies(cfgs []FunctionConfig, old map[string]*policy) map[string]*policy {
+	policies := map[string]*policy{}
+	for _, cfg := range cfgs {
+		p, ok := old[policyName(cfg)]
+		if !ok || !reflect.DeepEqual(p.cfg, cfg) {
+			p = a.newPolicy(cfg)
+		}
+		policies[p.name()] = p
+	}
+	return policies
+}
+
+// policyOf returns the policy of the message's function, the policy of its service is used if
+// the function has no policy of its own
+func (a *API) policyOf(message *baetyl.Message) *policy {
+	policies := a.current().policies
+	serviceName := message.Metadata["serviceName"]
+	if p, ok := policies[serviceName+"/"+message.Metadata["functionName"]]; ok {
+		return p
+	}
+	return policies[serviceName]
+}
+
+// callFunction invokes the message through the policies of its function
+func (a *API) callFunction(ctx context.Context, message *baetyl.Message) (*baetyl.Message, *invokeError) {
+	p := a.policyOf(message)
+	if p == nil {
+		return a.invoke(ctx, message)
+	}
+	return a.callCached(ctx, p, message)
+}
+
+// payloadKey identifies the invocations of the same function with the same payload
+func payloadKey(message *baetyl.Message) string {
+	sum := sha256.Sum256(message.Payload)
+	return message.Metadata["serviceName"] + "/" + message.Metadata["functionName"] + "/" + hex.EncodeToString(sum[:])
+}
+
+// callCached returns the cached response of the same payload, functions can opt out of caching
+// by setting 'cacheControl: no-store' in the metadata of the response
+func (a *API) callCached(ctx context.Context, p *policy, message *baetyl.Message) (*baetyl.Message, *invokeError) {
+	if p.cache == nil {
+		return a.callCoalesced(ctx, p, message)
+	}
+
+	key := payloadKey(message)
+	if payload, ok := p.cache.Get(key); ok {
+		atomic.AddUint64(&p.cacheHits, 1)
+		return &baetyl.Message{Metadata: message.Metadata, Payload: payload}, nil
+	}
+	atomic.AddUint64(&p.cacheMisses, 1)
+
+	resp, ierr := a.callCoalesced(ctx, p, message)
+	if ierr != nil {
+		return nil, ierr
+	}
+	if resp.Metadata["cacheControl"] == "no-store" {
+		a.log.Debug("function opts out of caching", log.Any("function", p.name()))
+	} else if len(resp.Payload) <= p.cfg.Cache.MaxEntrySize {
+		p.cache.Set(key, resp.Payload)
+	}
+	return resp, nil
+}
+
+// callCoalesced makes the concurrent invocations with the same payload share one call,
+// unlike caching nothing is kept once the call finishes
+func (a *API) callCoalesced(ctx context.Context, p *policy, message *baetyl.Message) (*baetyl.Message, *invokeError) {
+	if p.flights == nil {
+		return a.callBatched(ctx, p, message)
+	}
+
+	resp, ierr, shared := p.flights.Do(ctx, payloadKey(message), a.budgetOf(message), func(ctx context.Context) (*baetyl.Message, *invokeError) {
+		return a.callBatched(ctx, p, message)
+	})
+	if shared {
+		atomic.AddUint64(&p.coalesced, 1)
+	}
+	return resp, ierr
+}
+
+// callBatched sends the message with the others of the same function in one batch
+func (a *API) callBatched(ctx context.Context, p *policy, message *baetyl.Message) (*baetyl.Message, *invokeError) {
+	if p.batcher == nil {
+		return a.invoke(ctx, message)
+	}
+	return p.batcher.Do(ctx, message)
+}
@@ -0,0 +1,251 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCache(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "policy")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	srv := &mockGrpcServer{port: ports[0]}
+	s0 := mockGrpcServe(t, ports[0], serverCert, srv)
+	defer s0.GracefulStop()
+
+	cfg := newMockConfig(t)
+	err = utils.UnmarshalYAML([]byte(`
+functions:
+- service: serviceA
+  function: lookup
+  cache:
+    enable: true
+    maxEntrySize: 32
+`), &cfg)
+	assert.NoError(t, err)
+	api := newMockAPI(t, cfg, certPath, map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+	})
+	defer api.Close()
+
+	call := func(uri, body string) {
+		resp := doRequest(api, http.MethodPost, uri, []byte(body), nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Equal(t, fmt.Sprintf("{\"port\":%d}", ports[0]), string(resp.Body()))
+	}
+
+	call("/serviceA/lookup", "a")
+	call("/serviceA/lookup", "a")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&srv.calls))
+	call("/serviceA/lookup", "b")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&srv.calls))
+
+	// other functions are not cached
+	call("/serviceA/other", "a")
+	call("/serviceA/other", "a")
+	assert.Equal(t, int32(4), atomic.LoadInt32(&srv.calls))
+
+	// the function opts out of caching
+	call("/serviceA/lookup", "nostore")
+	call("/serviceA/lookup", "nostore")
+	assert.Equal(t, int32(6), atomic.LoadInt32(&srv.calls))
+
+	resp := doAdminRequest(api, http.MethodGet, "/_admin/metrics", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	var m Metrics
+	assert.NoError(t, json.Unmarshal(resp.Body(), &m))
+	assert.Equal(t, CacheMetrics{Hits: 1, Misses: 4, Entries: 2}, m.Cache["serviceA/lookup"])
+
+	// the metrics aren't served on the port of functions
+	resp = doRequest(api, http.MethodGet, "/_admin/metrics", nil, nil)
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode())
+}
+
+func TestCoalesce(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "coalesce")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	srv := &mockGrpcServer{port: ports[0], delay: 200 * time.Millisecond}
+	s0 := mockGrpcServe(t, ports[0], serverCert, srv)
+	defer s0.GracefulStop()
+
+	cfg := newMockConfig(t)
+	cfg.Functions = []FunctionConfig{{Service: "serviceA", Coalesce: true}}
+	api := newMockAPI(t, cfg, certPath, map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+	})
+	defer api.Close()
+
+	concurrently := func(n int, uri string, body func(i int) string) {
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				resp := doRequest(api, http.MethodPost, uri, []byte(body(i)), nil)
+				assert.Equal(t, http.StatusOK, resp.StatusCode())
+				assert.Equal(t, fmt.Sprintf("{\"port\":%d}", ports[0]), string(resp.Body()))
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	concurrently(5, "/serviceA/fn", func(int) string { return "same" })
+	assert.Equal(t, int32(1), atomic.LoadInt32(&srv.calls))
+
+	// nothing is kept once the call finishes
+	concurrently(1, "/serviceA/fn", func(int) string { return "same" })
+	assert.Equal(t, int32(2), atomic.LoadInt32(&srv.calls))
+
+	// different payloads are not coalesced
+	concurrently(3, "/serviceA/fn", func(i int) string { return fmt.Sprint(i) })
+	assert.Equal(t, int32(5), atomic.LoadInt32(&srv.calls))
+
+	assert.Equal(t, uint64(4), api.Metrics().Coalesced["serviceA"])
+}
+
+func TestBatch(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "batch")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	srv := &mockGrpcServer{port: ports[0]}
+	s0 := mockGrpcServe(t, ports[0], serverCert, srv)
+	defer s0.GracefulStop()
+
+	cfg := newMockConfig(t)
+	cfg.Functions = []FunctionConfig{{
+		Service: "serviceA",
+		Batch:   BatchConfig{Enable: true, MaxSize: 4, Window: 200 * time.Millisecond},
+	}}
+	api := newMockAPI(t, cfg, certPath, map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+	})
+	defer api.Close()
+
+	// a full batch is sent at once
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf("{\"i\":%d}", i)
+			if i%2 == 0 {
+				body = fmt.Sprintf("raw-%d", i)
+			}
+			// a json string is returned as is rather than unquoted
+			if i == 3 {
+				body = "\"json-3\""
+			}
+			resp := doRequest(api, http.MethodPost, "/serviceA/fn", []byte(body), nil)
+			assert.Equal(t, http.StatusOK, resp.StatusCode())
+			assert.Equal(t, body, string(resp.Body()))
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&srv.calls))
+
+	// a partial batch is sent when the window expires
+	start := time.Now()
+	resp := doRequest(api, http.MethodPost, "/serviceA/fn", []byte("single"), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "single", string(resp.Body()))
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&srv.calls))
+
+	// the functions of the service are batched separately
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp := doRequest(api, http.MethodPost, fmt.Sprintf("/serviceA/f%d", i%2), []byte(strconv.Itoa(i)), nil)
+			assert.Equal(t, http.StatusOK, resp.StatusCode())
+			assert.Equal(t, strconv.Itoa(i), string(resp.Body()))
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(4), atomic.LoadInt32(&srv.calls))
+}
+
+func TestBatchContext(t *testing.T) {
+	ctx1, cancel1 := context.WithTimeout(context.Background(), time.Minute)
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Second)
+	items := []*batchItem{{ctx: ctx1}, {ctx: ctx2}}
+
+	// the batch has the earliest deadline of the items
+	ctx, cancel := batchContext(context.Background(), items)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	expected, _ := ctx2.Deadline()
+	assert.Equal(t, expected, deadline)
+
+	// and is cancelled once all callers are gone
+	cancel1()
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, ctx.Err())
+	cancel2()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the batch isn't cancelled")
+	}
+}
+
+func TestBatchExpire(t *testing.T) {
+	b := newBatcher(BatchConfig{Enable: true, MaxSize: 10, Window: time.Minute}, nil)
+	stale := &pendingBatch{}
+	pb := &pendingBatch{items: []*batchItem{{}}}
+	b.pending["fn"] = pb
+
+	// the timer of the batch taken before doesn't flush the next one
+	b.expire("fn", stale)
+	assert.Equal(t, pb, b.pending["fn"])
+}
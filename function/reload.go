@@ -0,0 +1,209 @@
+package function
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetylhttp "github.com/baetyl/baetyl-go/v2/http"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/fsnotify/fsnotify"
+)
+
+// the changes of config file within the delay are reloaded once
+const reloadDelay = 100 * time.Millisecond
+
+// Reload applies the config to the running proxy. The client settings and the policies of functions
+// take effect for the following invocations, and the server is swapped gracefully only if the server
+// settings are changed. The settings of admin, dead-letter, queue, idempotency, access log, grpc server and scripts
+// take effect after restart.
+func (a *API) Reload(cfg Config) error {
+	a.reloadLock.Lock()
+	defer a.reloadLock.Unlock()
+
+	if a.Draining() {
+		return errors.New("the api is closed")
+	}
+	old := a.current()
+	if err := checkPolicies(cfg.Functions); err != nil {
+		return errors.Trace(err)
+	}
+
+	if !reflect.DeepEqual(cfg.Admin, old.cfg.Admin) ||
+		!reflect.DeepEqual(cfg.DeadLetter, old.cfg.DeadLetter) ||
+		!reflect.DeepEqual(cfg.Queue, old.cfg.Queue) ||
+		!reflect.DeepEqual(cfg.Idempotency, old.cfg.Idempotency) ||
+		!reflect.DeepEqual(cfg.AccessLog, old.cfg.AccessLog) ||
+		!reflect.DeepEqual(cfg.GrpcServer, old.cfg.GrpcServer) ||
+		!reflect.DeepEqual(cfg.Scripts, old.cfg.Scripts) {
+		a.log.Warn("the changes of admin, deadletter, queue, idempotency, accesslog, grpcserver and scripts take effect after restart")
+	}
+	cfg.Admin = old.cfg.Admin
+	cfg.DeadLetter = old.cfg.DeadLetter
+	cfg.Queue = old.cfg.Queue
+	cfg.Idempotency = old.cfg.Idempotency
+	cfg.AccessLog = old.cfg.AccessLog
+	cfg.GrpcServer = old.cfg.GrpcServer
+	cfg.Scripts = old.cfg.Scripts
+	cfg.Server.Address = old.cfg.Server.Address
+	cfg.Server.Certificate = old.cfg.Server.Certificate
+
+	if !reflect.DeepEqual(cfg.Server, old.cfg.Server) {
+		if err := a.swapServer(cfg.Server, old.cfg.Server); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	a.settings.Store(&settings{cfg: &cfg, policies: a.newPolicies(cfg.Functions, old.policies)})
+	return nil
+}
+
+// swapServer replaces the server. The new one serves on a duplicate of the listening socket before the old one
+// stops accepting connections, and the old one finishes its in-flight requests in background. If the socket can't
+// be duplicated, the old one is closed first to release the address, and restarted if the new one fails to listen
+func (a *API) swapServer(cfg, oldCfg baetylhttp.ServerConfig) error {
+	a.svrLock.Lock()
+	defer a.svrLock.Unlock()
+
+	old, oldLn := a.svr, a.ln
+	closeOld := func() {
+		old.Close()
+		oldLn.Close()
+	}
+
+	ln, err := dupListener(oldLn)
+	if err == nil {
+		a.svr, a.ln = a.serve(cfg, a.handler, ln), ln
+		go closeOld()
+		return nil
+	}
+	a.log.Debug("failed to duplicate listener, the server is restarted", log.Error(err))
+
+	closeOld()
+	svr, ln, err := a.startServer(cfg, a.handler)
+	if err != nil {
+		a.log.Error("failed to start new server, the old one is restarted", log.Error(err))
+		if svr, ln, rerr := a.startServer(oldCfg, a.handler); rerr == nil {
+			a.svr, a.ln = svr, ln
+		} else {
+			a.log.Error("failed to restart old server", log.Error(rerr))
+		}
+		return errors.Trace(err)
+	}
+	a.svr, a.ln = svr, ln
+	return nil
+}
+
+// dupListener returns a listener on a duplicate of the socket, which keeps listening after the original one is closed
+func dupListener(ln net.Listener) (net.Listener, error) {
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		return nil, errors.Errorf("listener (%T) can't be duplicated", ln)
+	}
+	f, err := tl.File()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+	dup, err := net.FileListener(f)
+	return dup, errors.Trace(err)
+}
+
+// configWatcher reloads the config file when it changes. The directory is watched so that the file
+// replaced by rename or symlink, such as a mounted config map, is noticed as well
+type configWatcher struct {
+	file    string
+	digest  [sha256.Size]byte
+	watcher *fsnotify.Watcher
+	reload  func(Config) error
+	done    chan struct{}
+	log     *log.Logger
+}
+
+func newConfigWatcher(file string, reload func(Config) error) (*configWatcher, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return nil, errors.Trace(err)
+	}
+	w := &configWatcher{
+		file:    file,
+		digest:  sha256.Sum256(data),
+		watcher: watcher,
+		reload:  reload,
+		done:    make(chan struct{}),
+		log:     log.With(log.Any("function", "watcher"), log.Any("file", file)),
+	}
+	go w.watching()
+	return w, nil
+}
+
+func (w *configWatcher) watching() {
+	defer close(w.done)
+
+	var delay <-chan time.Time
+	for {
+		select {
+		case e, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			// the data of a config map is switched by renaming the '..data' symlink
+			name := filepath.Base(e.Name)
+			if name == filepath.Base(w.file) || strings.HasPrefix(name, "..") {
+				delay = time.After(reloadDelay)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.log.Warn("failed to watch config file", log.Error(err))
+		case <-delay:
+			delay = nil
+			w.load()
+		}
+	}
+}
+
+func (w *configWatcher) load() {
+	data, err := ioutil.ReadFile(w.file)
+	if err != nil {
+		w.log.Warn("failed to read config file", log.Error(err))
+		return
+	}
+	digest := sha256.Sum256(data)
+	if digest == w.digest {
+		return
+	}
+	w.digest = digest
+
+	var cfg Config
+	if err = utils.LoadYAML(w.file, &cfg); err != nil {
+		w.log.Error("the invalid config is rejected", log.Error(err))
+		return
+	}
+	if err = w.reload(cfg); err != nil {
+		w.log.Error("failed to reload config", log.Error(err))
+		return
+	}
+	w.log.Info("config is reloaded")
+}
+
+// Close stops watching and waits for the reload in progress
+func (w *configWatcher) Close() error {
+	err := w.watcher.Close()
+	<-w.done
+	return errors.Trace(err)
+}
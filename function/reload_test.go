@@ -0,0 +1,126 @@
+package function
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReload(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "reload")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	srv := &mockGrpcServer{port: ports[0]}
+	s0 := mockGrpcServe(t, ports[0], serverCert, srv)
+	defer s0.GracefulStop()
+
+	api := newMockAPI(t, newMockConfig(t), certPath, map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+	})
+	defer api.Close()
+	waitServer(t, "localhost:50011")
+
+	call := func() {
+		resp := doRequest(api, http.MethodPost, "/serviceA", []byte("payload"), nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+	}
+
+	// client settings and policies
+	cfg := newMockConfig(t)
+	cfg.Client.Grpc.Retries = 5
+	cfg.Functions = []FunctionConfig{{Service: "serviceA", Cache: CacheConfig{Enable: true, TTL: time.Minute, MaxEntries: 10, MaxEntrySize: 1024}}}
+	svr := api.svr
+	assert.NoError(t, api.Reload(cfg))
+	assert.Equal(t, 5, api.current().cfg.Client.Grpc.Retries)
+	assert.Equal(t, svr, api.svr)
+	call()
+	call()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&srv.calls))
+
+	// the policy is kept if not changed
+	assert.NoError(t, api.Reload(cfg))
+	call()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&srv.calls))
+	assert.Equal(t, uint64(2), api.Metrics().Cache["serviceA"].Hits)
+
+	// invalid config is rejected
+	invalid := cfg
+	invalid.Functions = []FunctionConfig{{Service: "serviceA"}, {Service: "serviceA"}}
+	assert.Error(t, api.Reload(invalid))
+	assert.Len(t, api.current().policies, 1)
+
+	// the server is swapped if its settings change
+	cfg.Server.ReadTimeout = time.Minute
+	assert.NoError(t, api.Reload(cfg))
+	assert.NotEqual(t, svr, api.svr)
+	assert.Equal(t, time.Minute, api.svr.ReadTimeout)
+	waitServer(t, "localhost:50011")
+
+	// the same validation applies at start
+	_, err = NewAPI(invalid, &mockContext{}, &mockResolver{})
+	assert.EqualError(t, err, "duplicate policies of function (serviceA)")
+}
+
+func TestConfigWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watcher")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "conf.yml")
+	err = ioutil.WriteFile(file, []byte("client:\n  grpc:\n    retries: 3\n"), 0644)
+	assert.NoError(t, err)
+
+	reloaded := make(chan Config, 10)
+	w, err := newConfigWatcher(file, func(cfg Config) error {
+		reloaded <- cfg
+		return nil
+	})
+	assert.NoError(t, err)
+	defer w.Close()
+
+	err = ioutil.WriteFile(file, []byte("client:\n  grpc:\n    retries: 5\n"), 0644)
+	assert.NoError(t, err)
+	select {
+	case cfg := <-reloaded:
+		assert.Equal(t, 5, cfg.Client.Grpc.Retries)
+		assert.Equal(t, 16, cfg.Fanout.MaxTargets)
+	case <-time.After(5 * time.Second):
+		t.Fatal("config is not reloaded")
+	}
+
+	err = ioutil.WriteFile(file, []byte("functions:\n  - function: fn\n"), 0644)
+	assert.NoError(t, err)
+	select {
+	case <-reloaded:
+		t.Fatal("invalid config is reloaded")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// the watching goroutine exits once closed
+	assert.NoError(t, w.Close())
+	select {
+	case <-w.done:
+	default:
+		t.Fatal("the watcher is still running")
+	}
+}
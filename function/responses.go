@@ -2,6 +2,10 @@ package function
 
 import (
 	"encoding/json"
+	"strconv"
+	"time"
+
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
 	routing "github.com/qiangxue/fasthttp-routing"
 )
 
@@ -9,10 +13,31 @@ const (
 	jsonContentTypeHeader = "application/json"
 )
 
+// the headers of the response of an invocation, they match the fields of ErrorResponse
+const (
+	headerInvokeId  = "X-Baetyl-Invoke-Id"
+	headerService   = "X-Baetyl-Service"
+	headerFunction  = "X-Baetyl-Function"
+	headerAttempts  = "X-Baetyl-Attempts"
+	headerBackend   = "X-Baetyl-Backend"
+	headerGrpcCode  = "X-Baetyl-Grpc-Code"
+	headerTimestamp = "X-Baetyl-Timestamp"
+)
+
+// the message of failed invocations for the callers from which the internal details are hidden
+const hiddenErrorMessage = "the function invocation failed"
+
 // ErrorResponse ErrorResponse
 type ErrorResponse struct {
-	ErrCode string `json:"errCode"`
-	Message string `json:"message"`
+	ErrCode   string `json:"errCode"`
+	Message   string `json:"message"`
+	InvokeId  string `json:"invokeId,omitempty"`
+	Service   string `json:"service,omitempty"`
+	Function  string `json:"function,omitempty"`
+	Attempts  int    `json:"attempts,omitempty"`
+	Backend   string `json:"backend,omitempty"`
+	GrpcCode  string `json:"grpcCode,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
 }
 
 // NewErrorResponse NewErrorResponse
@@ -23,6 +48,66 @@ func NewErrorResponse(errCode, message string) ErrorResponse {
 	}
 }
 
+// newInvokeErrorResponse returns the error response of a failed invocation, the error message,
+// the attempts, the backend and the grpc code are internal details which are left out if hidden
+func newInvokeErrorResponse(message *baetyl.Message, ierr *invokeError, hidden bool) ErrorResponse {
+	resp := NewErrorResponse(ierr.errCode, ierr.err.Error())
+	resp.InvokeId = message.Metadata["invokeId"]
+	resp.Service = message.Metadata["serviceName"]
+	resp.Function = message.Metadata["functionName"]
+	resp.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	if hidden {
+		resp.Message = hiddenErrorMessage
+		return resp
+	}
+	resp.Attempts = ierr.attempts
+	resp.Backend = ierr.address
+	resp.GrpcCode = ierr.grpcCode
+	return resp
+}
+
+// hidesDetails returns whether the internal details of errors are hidden from the caller
+func (a *API) hidesDetails(c *routing.Context) bool {
+	return a.hidesDetailsFrom(callerOf(c))
+}
+
+// hidesDetailsFrom returns whether the internal details of errors are hidden from the caller of the name
+func (a *API) hidesDetailsFrom(caller string) bool {
+	cfg := a.current().cfg.Errors
+	if !cfg.HideDetails {
+		return false
+	}
+	for _, trusted := range cfg.TrustedCallers {
+		if trusted == caller {
+			return false
+		}
+	}
+	return true
+}
+
+// respondInvokeError responds the error of a failed invocation, the details are set in both body and headers
+func (a *API) respondInvokeError(c *routing.Context, message *baetyl.Message, ierr *invokeError) {
+	resp := newInvokeErrorResponse(message, ierr, a.hidesDetails(c))
+	headers := map[string]string{
+		headerInvokeId:  resp.InvokeId,
+		headerService:   resp.Service,
+		headerFunction:  resp.Function,
+		headerBackend:   resp.Backend,
+		headerGrpcCode:  resp.GrpcCode,
+		headerTimestamp: resp.Timestamp,
+	}
+	if resp.Attempts > 0 {
+		headers[headerAttempts] = strconv.Itoa(resp.Attempts)
+	}
+	for k, v := range headers {
+		if v != "" {
+			c.Response.Header.Set(k, v)
+		}
+	}
+	b, _ := json.Marshal(&resp)
+	respond(c, ierr.code, b)
+}
+
 func respondError(c *routing.Context, code int, errCode, msg string) {
 	resp := NewErrorResponse(errCode, msg)
 	b, _ := json.Marshal(&resp)
@@ -0,0 +1,55 @@
+package function
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	routing "github.com/qiangxue/fasthttp-routing"
+)
+
+// track counts the invocation in flight until the returned function is called
+func (a *API) track() func() {
+	atomic.AddInt64(&a.inflight, 1)
+	return func() {
+		atomic.AddInt64(&a.inflight, -1)
+	}
+}
+
+// Inflight returns the number of invocations in flight
+func (a *API) Inflight() int64 {
+	return atomic.LoadInt64(&a.inflight)
+}
+
+// Draining returns whether the api is shutting down
+func (a *API) Draining() bool {
+	return atomic.LoadInt32(&a.draining) == 1
+}
+
+// drain waits up to the drain timeout for the invocations in flight
+func (a *API) drain() {
+	timeout := a.current().cfg.Shutdown.DrainTimeout
+	a.log.Info("api is draining", log.Any("inflight", a.Inflight()), log.Any("timeout", timeout))
+
+	deadline := time.Now().Add(timeout)
+	for a.Inflight() > 0 {
+		if time.Now().After(deadline) {
+			a.log.Warn("drain timeout, the invocations in flight are cut off", log.Any("inflight", a.Inflight()))
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.log.Info("api is drained")
+}
+
+// rejectDraining rejects new requests once the api starts to shut down, the admin endpoints are still served
+// on the admin listener
+func (a *API) rejectDraining(c *routing.Context) error {
+	if a.Draining() {
+		respondError(c, http.StatusServiceUnavailable, "ERR_SHUTTING_DOWN", "the proxy is shutting down")
+		c.Abort()
+		return nil
+	}
+	return c.Next()
+}
@@ -0,0 +1,82 @@
+package function
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "shutdown")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	s0 := mockGrpcServe(t, ports[0], serverCert, &mockGrpcServer{port: ports[0], delay: 500 * time.Millisecond})
+	defer s0.GracefulStop()
+
+	tests := []struct {
+		name         string
+		drainTimeout time.Duration
+		code         int
+	}{
+		{name: "drained", drainTimeout: 5 * time.Second, code: http.StatusOK},
+		{name: "drain timeout", drainTimeout: 100 * time.Millisecond, code: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newMockConfig(t)
+			cfg.Shutdown.DrainTimeout = tt.drainTimeout
+			api := newMockAPI(t, cfg, certPath, map[string]string{
+				"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+			})
+
+			resp := doAdminRequest(api, http.MethodGet, "/_admin/ready", nil)
+			assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+			done := make(chan int)
+			go func() {
+				done <- doRequest(api, http.MethodPost, "/serviceA", []byte("payload"), nil).StatusCode()
+			}()
+			for api.Inflight() == 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			closed := make(chan struct{})
+			go func() {
+				api.Close()
+				close(closed)
+			}()
+			for !api.Draining() {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			// the readiness fails and new requests are rejected while draining
+			resp = doAdminRequest(api, http.MethodGet, "/_admin/ready", nil)
+			assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode())
+			resp = doRequest(api, http.MethodPost, "/serviceA", []byte("payload"), nil)
+			assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode())
+			assert.Equal(t, int64(1), api.Metrics().Inflight)
+
+			assert.Equal(t, tt.code, <-done)
+			<-closed
+			assert.Equal(t, int64(0), api.Inflight())
+		})
+	}
+}
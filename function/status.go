@@ -0,0 +1,60 @@
+package function
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/baetyl/baetyl-function/v2/errdetail"
+)
+
+// the reasons of errdetail, exported here for the in-process handlers to fill RuntimeError
+const (
+	ReasonUserCode          = errdetail.ReasonUserCode
+	ReasonFunctionNotFound  = errdetail.ReasonFunctionNotFound
+	ReasonBadInput          = errdetail.ReasonBadInput
+	ReasonTimeout           = errdetail.ReasonTimeout
+	ReasonResourceExhausted = errdetail.ReasonResourceExhausted
+)
+
+// RuntimeError is errdetail.Error, an in-process handler returns it to choose the status of the caller and the retries
+type RuntimeError = errdetail.Error
+
+// runtimeErrorOf returns the structured error of a failed call, by which callError maps the status
+func runtimeErrorOf(err error) *RuntimeError {
+	return errdetail.Of(err)
+}
+
+// callError maps the failure of calling a runtime to the error reported to the caller, so that
+// the error of functions can be told from the outage of runtimes
+func callError(err error, attempts int) *invokeError {
+	ierr := &invokeError{code: http.StatusInternalServerError, errCode: "ERR_FUNCTION_CALL", err: err, attempts: attempts}
+	if st, ok := status.FromError(err); ok && err != nil {
+		ierr.grpcCode = st.Code().String()
+	}
+	if rerr := runtimeErrorOf(err); rerr != nil {
+		switch rerr.Reason {
+		case ReasonFunctionNotFound:
+			ierr.code, ierr.errCode = http.StatusNotFound, "ERR_FUNCTION_NOT_FOUND"
+		case ReasonBadInput:
+			ierr.code, ierr.errCode = http.StatusBadRequest, "ERR_FUNCTION_BAD_INPUT"
+		case ReasonTimeout:
+			ierr.code, ierr.errCode = http.StatusGatewayTimeout, "ERR_FUNCTION_TIMEOUT"
+		case ReasonUserCode:
+			ierr.code, ierr.errCode = http.StatusInternalServerError, "ERR_FUNCTION_USER_CODE"
+		case ReasonResourceExhausted:
+			ierr.code, ierr.errCode = http.StatusTooManyRequests, "ERR_FUNCTION_BUSY"
+		}
+		return ierr
+	}
+	switch status.Code(err) {
+	case codes.DeadlineExceeded:
+		ierr.code, ierr.errCode = http.StatusGatewayTimeout, "ERR_FUNCTION_TIMEOUT"
+	case codes.Unavailable:
+		ierr.code, ierr.errCode = http.StatusServiceUnavailable, "ERR_FUNCTION_UNAVAILABLE"
+	case codes.ResourceExhausted:
+		ierr.code, ierr.errCode = http.StatusTooManyRequests, "ERR_FUNCTION_BUSY"
+	}
+	return ierr
+}
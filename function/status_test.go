@@ -0,0 +1,174 @@
+package function
+
+import (
+	context2 "context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusServer reports the structured error whose reason is the payload, a retryable
+// error is reported only for the first call
+type statusServer struct {
+	calls int32
+}
+
+func (s *statusServer) Call(ctx context2.Context, msg *baetyl.Message) (*baetyl.Message, error) {
+	calls := atomic.AddInt32(&s.calls, 1)
+	reason := string(msg.Payload)
+	if reason == "" || (reason == "retryable" && calls > 1) {
+		return msg, nil
+	}
+	st, err := status.New(codes.Unknown, "failed").WithDetails(&structpb.Struct{
+		Fields: map[string]*structpb.Value{
+			"reason":    {Kind: &structpb.Value_StringValue{StringValue: reason}},
+			"message":   {Kind: &structpb.Value_StringValue{StringValue: "failed"}},
+			"retryable": {Kind: &structpb.Value_BoolValue{BoolValue: reason == "retryable"}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nil, st.Err()
+}
+
+func TestRuntimeError(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "status")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	srv := &statusServer{}
+	s0 := mockGrpcServe(t, ports[0], serverCert, srv)
+	defer s0.GracefulStop()
+
+	cfg := newMockConfig(t)
+	cfg.Client.Grpc.Backoff = 100 * time.Millisecond
+	api := newMockAPI(t, cfg, certPath, map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+	})
+	defer api.Close()
+
+	tests := []struct {
+		reason  string
+		code    int
+		errCode string
+	}{
+		{reason: ReasonFunctionNotFound, code: http.StatusNotFound, errCode: "ERR_FUNCTION_NOT_FOUND"},
+		{reason: ReasonBadInput, code: http.StatusBadRequest, errCode: "ERR_FUNCTION_BAD_INPUT"},
+		{reason: ReasonTimeout, code: http.StatusGatewayTimeout, errCode: "ERR_FUNCTION_TIMEOUT"},
+		{reason: ReasonUserCode, code: http.StatusInternalServerError, errCode: "ERR_FUNCTION_USER_CODE"},
+		{reason: ReasonResourceExhausted, code: http.StatusTooManyRequests, errCode: "ERR_FUNCTION_BUSY"},
+		{reason: "UNKNOWN_REASON", code: http.StatusInternalServerError, errCode: "ERR_FUNCTION_CALL"},
+		{reason: "retryable", code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			atomic.StoreInt32(&srv.calls, 0)
+			start := time.Now()
+			resp := doRequest(api, http.MethodPost, "/serviceA", []byte(tt.reason), nil)
+			assert.Equal(t, tt.code, resp.StatusCode())
+			if tt.code == http.StatusOK {
+				assert.Equal(t, int32(2), atomic.LoadInt32(&srv.calls))
+				// the retry waits for the backoff
+				assert.True(t, time.Since(start) >= cfg.Client.Grpc.Backoff)
+				return
+			}
+			assert.Equal(t, int32(1), atomic.LoadInt32(&srv.calls))
+			var e ErrorResponse
+			assert.NoError(t, json.Unmarshal(resp.Body(), &e))
+			assert.Equal(t, tt.errCode, e.ErrCode)
+		})
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "details")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	s0 := mockGrpcServe(t, ports[0], serverCert, &statusServer{})
+	defer s0.GracefulStop()
+
+	backend := fmt.Sprintf("127.0.0.1:%d", ports[0])
+	api := newMockAPI(t, newMockConfig(t), certPath, map[string]string{"serviceA": backend})
+	defer api.Close()
+
+	call := func() (*ErrorResponse, map[string]string) {
+		resp := doRequest(api, http.MethodPost, "/serviceA/fn", []byte(ReasonUserCode), map[string]string{"invokeid": "id-1"})
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode())
+		var e ErrorResponse
+		assert.NoError(t, json.Unmarshal(resp.Body(), &e))
+		headers := map[string]string{}
+		resp.Header.VisitAll(func(k, v []byte) {
+			headers[string(k)] = string(v)
+		})
+		return &e, headers
+	}
+
+	e, headers := call()
+	assert.Equal(t, "ERR_FUNCTION_USER_CODE", e.ErrCode)
+	assert.Equal(t, "id-1", e.InvokeId)
+	assert.Equal(t, "serviceA", e.Service)
+	assert.Equal(t, "fn", e.Function)
+	assert.Equal(t, 1, e.Attempts)
+	assert.Equal(t, backend, e.Backend)
+	assert.Equal(t, "Unknown", e.GrpcCode)
+	assert.NotEmpty(t, e.Timestamp)
+	assert.Equal(t, "id-1", headers[headerInvokeId])
+	assert.Equal(t, "1", headers[headerAttempts])
+	assert.Equal(t, backend, headers[headerBackend])
+	assert.Equal(t, "Unknown", headers[headerGrpcCode])
+
+	// the internal details are hidden from the callers not trusted
+	cfg := newMockConfig(t)
+	cfg.Errors.HideDetails = true
+	assert.NoError(t, api.Reload(cfg))
+	e, headers = call()
+	assert.Equal(t, "ERR_FUNCTION_USER_CODE", e.ErrCode)
+	assert.Equal(t, "id-1", e.InvokeId)
+	assert.Equal(t, hiddenErrorMessage, e.Message)
+	assert.Zero(t, e.Attempts)
+	assert.Empty(t, e.Backend)
+	assert.Empty(t, e.GrpcCode)
+	assert.Empty(t, headers[headerBackend])
+
+	// the caller without certificate is identified by its remote ip
+	cfg.Errors.TrustedCallers = []string{"0.0.0.0"}
+	assert.NoError(t, api.Reload(cfg))
+	e, _ = call()
+	assert.Equal(t, backend, e.Backend)
+}
@@ -0,0 +1,34 @@
+package function
+
+import (
+	"fmt"
+	"time"
+
+	routing "github.com/qiangxue/fasthttp-routing"
+)
+
+const headerServerTiming = "Server-Timing"
+
+// serverTiming returns the durations of resolving the backend, calling the runtime and the whole request
+// in the format of Server-Timing header, which are in milliseconds
+func (t *trace) serverTiming() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return fmt.Sprintf("resolve;dur=%.3f, call;dur=%.3f, total;dur=%.3f",
+		milliseconds(t.resolve), milliseconds(t.grpc), milliseconds(time.Since(t.start)))
+}
+
+// setTimingHeaders sets the backend and the timing of the invocation in the response headers,
+// the backend is an internal detail which is hidden from the callers not trusted
+func (a *API) setTimingHeaders(c *routing.Context, t *trace) {
+	c.Response.Header.Set(headerServerTiming, t.serverTiming())
+	if a.hidesDetails(c) {
+		return
+	}
+	t.lock.Lock()
+	address := t.address
+	t.lock.Unlock()
+	if address != "" {
+		c.Response.Header.Set(headerBackend, address)
+	}
+}
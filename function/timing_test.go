@@ -0,0 +1,70 @@
+package function
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimingHeaders(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "timing")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	s0 := mockGrpcServe(t, ports[0], serverCert, &statusServer{})
+	defer s0.GracefulStop()
+
+	backend := fmt.Sprintf("127.0.0.1:%d", ports[0])
+	api := newMockAPI(t, newMockConfig(t), certPath, map[string]string{"serviceA": backend})
+	defer api.Close()
+
+	// the invokeId is generated if not given
+	resp := doRequest(api, http.MethodPost, "/serviceA", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.NotEmpty(t, string(resp.Header.Peek(headerInvokeId)))
+	assert.Equal(t, backend, string(resp.Header.Peek(headerBackend)))
+	timing := string(resp.Header.Peek(headerServerTiming))
+	for _, m := range []string{"resolve;dur=", "call;dur=", "total;dur="} {
+		assert.True(t, strings.Contains(timing, m), timing)
+	}
+
+	resp = doRequest(api, http.MethodPost, "/serviceA", []byte(ReasonBadInput), map[string]string{"invokeid": "id-1"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	assert.Equal(t, "id-1", string(resp.Header.Peek(headerInvokeId)))
+	assert.Equal(t, backend, string(resp.Header.Peek(headerBackend)))
+	assert.NotEmpty(t, string(resp.Header.Peek(headerServerTiming)))
+
+	// the requests rejected before the invocation carry the timing as well
+	resp = doRequest(api, http.MethodPost, "/serviceA", nil, map[string]string{headerTimeout: "soon"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	assert.NotEmpty(t, string(resp.Header.Peek(headerServerTiming)))
+	resp = doRequest(api, http.MethodPost, "/serviceA", nil, map[string]string{headerAsync: "true"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	assert.NotEmpty(t, string(resp.Header.Peek(headerServerTiming)))
+
+	// the address of backend is hidden from the callers not trusted
+	cfg := newMockConfig(t)
+	cfg.Errors.HideDetails = true
+	assert.NoError(t, api.Reload(cfg))
+	resp = doRequest(api, http.MethodPost, "/serviceA", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Empty(t, string(resp.Header.Peek(headerBackend)))
+	assert.NotEmpty(t, string(resp.Header.Peek(headerServerTiming)))
+}
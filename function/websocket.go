@@ -0,0 +1,155 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/fasthttp/websocket"
+	routing "github.com/qiangxue/fasthttp-routing"
+)
+
+// WebSocketRequest the frame sent by the client to invoke a function, the payload which isn't json
+// is sent as a json string
+type WebSocketRequest struct {
+	Service  string          `json:"service"`
+	Function string          `json:"function,omitempty"`
+	InvokeId string          `json:"invokeId,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+}
+
+// WebSocketResponse the frame sent back for each request, it is tagged with the invokeId of the request
+type WebSocketResponse struct {
+	InvokeId string         `json:"invokeId"`
+	Payload  interface{}    `json:"payload,omitempty"`
+	Error    *ErrorResponse `json:"error,omitempty"`
+}
+
+// webSocketEndpoints serves the handshake on GET as the websocket protocol requires, the requests are
+// sent as frames on the connection afterwards
+func (a *API) webSocketEndpoints() []Endpoint {
+	return []Endpoint{
+		{
+			Methods: []string{http.MethodGet},
+			Route:   "/_ws",
+			Handler: a.onWebSocket,
+		},
+	}
+}
+
+// onWebSocket upgrades the request to a websocket connection, on which the requests are invoked concurrently
+// and the responses are sent back once they return, so their order may differ from the requests
+func (a *API) onWebSocket(c *routing.Context) error {
+	cfg := a.current().cfg.WebSocket
+	if !cfg.Enable {
+		respondError(c, 400, "ERR_WEBSOCKET_DISABLED", "websocket invocation is not enabled")
+		return nil
+	}
+
+	// the request context is released once upgraded, so the caller is checked before
+	hidden := a.hidesDetails(c)
+	upgrader := websocket.FastHTTPUpgrader{}
+	err := upgrader.Upgrade(c.RequestCtx, func(conn *websocket.Conn) {
+		a.serveWebSocket(conn, cfg, hidden)
+	})
+	if err != nil {
+		a.log.Debug("failed to upgrade to websocket", log.Error(err))
+	}
+	return nil
+}
+
+func (a *API) serveWebSocket(conn *websocket.Conn, cfg WebSocketConfig, hidden bool) {
+	a.addSocket(conn)
+	defer a.removeSocket(conn)
+
+	// the invocations in flight are cancelled once the connection is closed
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+		conn.Close()
+	}()
+
+	var lock sync.Mutex
+	send := func(resp *WebSocketResponse) {
+		lock.Lock()
+		defer lock.Unlock()
+		if err := conn.WriteJSON(resp); err != nil {
+			a.log.Debug("failed to send websocket response", log.Any("invokeId", resp.InvokeId), log.Error(err))
+		}
+	}
+
+	a.log.Info("websocket connection is opened", log.Any("remote", conn.RemoteAddr().String()))
+	conn.SetReadLimit(cfg.MaxMessageSize)
+	tokens := make(chan struct{}, cfg.MaxConcurrency)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			a.log.Info("websocket connection is closed", log.Any("remote", conn.RemoteAddr().String()), log.Error(err))
+			return
+		}
+		var req WebSocketRequest
+		if err = json.Unmarshal(data, &req); err != nil || req.Service == "" {
+			e := NewErrorResponse("ERR_INVALID_FRAME", "the frame is not a json object with service")
+			send(&WebSocketResponse{InvokeId: req.InvokeId, Error: &e})
+			continue
+		}
+		if a.Draining() {
+			e := NewErrorResponse("ERR_SHUTTING_DOWN", "the proxy is shutting down")
+			send(&WebSocketResponse{InvokeId: req.InvokeId, Error: &e})
+			continue
+		}
+
+		// the following requests wait once the connection reaches its max concurrency
+		tokens <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+			send(a.invokeWebSocket(ctx, &req, hidden))
+		}()
+	}
+}
+
+func (a *API) invokeWebSocket(ctx context.Context, req *WebSocketRequest, hidden bool) *WebSocketResponse {
+	invokeId, provided := req.InvokeId, req.InvokeId != ""
+	if !provided {
+		invokeId = newInvokeId()
+	}
+	message := newMessage(req.Service, req.Function, invokeId, unwrapElement(req.Payload))
+	resp, ierr := a.call(ctx, &message, provided)
+	if ierr != nil {
+		if ctx.Err() != context.Canceled {
+			a.putDeadLetter(&message, ierr)
+		}
+		e := newInvokeErrorResponse(&message, ierr, hidden)
+		return &WebSocketResponse{InvokeId: invokeId, Error: &e}
+	}
+	return &WebSocketResponse{InvokeId: invokeId, Payload: fanoutPayload(resp.Payload)}
+}
+
+func (a *API) addSocket(conn *websocket.Conn) {
+	a.socketLock.Lock()
+	defer a.socketLock.Unlock()
+	a.sockets[conn] = struct{}{}
+}
+
+func (a *API) removeSocket(conn *websocket.Conn) {
+	a.socketLock.Lock()
+	defer a.socketLock.Unlock()
+	delete(a.sockets, conn)
+}
+
+// closeSockets closes the websocket connections, which are hijacked and not closed by the server
+func (a *API) closeSockets() {
+	a.socketLock.Lock()
+	defer a.socketLock.Unlock()
+	for conn := range a.sockets {
+		conn.Close()
+	}
+}
@@ -0,0 +1,94 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebSocket(t *testing.T) {
+	cmd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	certPath := path.Join(cmd, "websocket")
+	initCert(t, certPath)
+	defer os.RemoveAll(certPath)
+
+	serverCert := utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "key.pem"),
+		Cert: path.Join(certPath, "crt.pem"),
+	}
+
+	ports, err := getFreePorts(1)
+	assert.NoError(t, err)
+	delay := 300 * time.Millisecond
+	s0 := mockGrpcServe(t, ports[0], serverCert, &mockGrpcServer{port: ports[0], delay: delay})
+	defer s0.GracefulStop()
+
+	cfg := newMockConfig(t)
+	api := newMockAPI(t, cfg, certPath, map[string]string{
+		"serviceA": fmt.Sprintf("127.0.0.1:%d", ports[0]),
+	})
+	defer api.Close()
+	waitServer(t, "localhost:50011")
+
+	resp := doRequest(api, http.MethodGet, "/_ws", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	cfg.WebSocket.Enable = true
+	cfg.WebSocket.MaxConcurrency = 2
+	assert.NoError(t, api.Reload(cfg))
+
+	tlsConfig, err := utils.NewTLSConfigClient(utils.Certificate{
+		CA:   path.Join(certPath, "ca.pem"),
+		Key:  path.Join(certPath, "clientKey.pem"),
+		Cert: path.Join(certPath, "clientCrt.pem"),
+	})
+	assert.NoError(t, err)
+	// the server certificate of mock api has no names
+	tlsConfig.InsecureSkipVerify = true
+	dialer := websocket.Dialer{TLSClientConfig: tlsConfig}
+	conn, _, err := dialer.Dial("wss://localhost:50011/_ws", nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	start := time.Now()
+	ids := []string{"id-1", "id-2", "id-3", "id-4"}
+	for _, id := range ids {
+		err = conn.WriteJSON(&WebSocketRequest{Service: "serviceA", InvokeId: id, Payload: json.RawMessage(`"payload"`)})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("invalid")))
+
+	received := map[string]bool{}
+	invalid := false
+	for i := 0; i < len(ids)+1; i++ {
+		var r struct {
+			InvokeId string         `json:"invokeId"`
+			Payload  map[string]int `json:"payload"`
+			Error    *ErrorResponse `json:"error"`
+		}
+		assert.NoError(t, conn.ReadJSON(&r))
+		if r.Error != nil {
+			assert.Equal(t, "ERR_INVALID_FRAME", r.Error.ErrCode)
+			invalid = true
+			continue
+		}
+		assert.Equal(t, ports[0], r.Payload["port"])
+		received[r.InvokeId] = true
+	}
+	assert.True(t, invalid)
+	assert.Len(t, received, len(ids))
+	// two requests are invoked at a time
+	assert.True(t, time.Since(start) >= 2*delay)
+	assert.True(t, time.Since(start) < 4*delay)
+}
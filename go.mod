@@ -1,14 +1,65 @@
 module github.com/baetyl/baetyl-function/v2
 
-go 1.13
+go 1.18
 
 require (
 	github.com/baetyl/baetyl-go/v2 v2.2.4-0.20220114042103-4ba035e5dfb7
 	github.com/docker/distribution v2.7.1+incompatible
-	github.com/golang/protobuf v1.3.5 // indirect
+	github.com/dop251/goja v0.0.0-20211022113120-dc8c55024d06
+	github.com/fasthttp/websocket v1.4.2
+	github.com/fsnotify/fsnotify v1.4.7
+	github.com/golang/protobuf v1.3.5
 	github.com/qiangxue/fasthttp-routing v0.0.0-20160225050629-6ccdc2a18d87
 	github.com/stretchr/testify v1.5.1
+	github.com/tetratelabs/wazero v1.2.1
 	github.com/valyala/fasthttp v1.9.0
-	golang.org/x/tools v0.0.0-20191205225056-3393d29bb9fe // indirect
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/sys v0.0.0-20200923182605-d9f96fdee20d
 	google.golang.org/grpc v1.28.0
 )
+
+require (
+	github.com/256dpi/gomqtt v0.14.3 // indirect
+	github.com/256dpi/mercury v0.2.0 // indirect
+	github.com/containerd/containerd v1.3.4 // indirect
+	github.com/creasty/defaults v1.4.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dlclark/regexp2 v1.4.1-0.20201116162257-a2a8dda75c91 // indirect
+	github.com/docker/go-connections v0.4.0 // indirect
+	github.com/docker/go-units v0.4.0 // indirect
+	github.com/dsnet/compress v0.0.1 // indirect
+	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
+	github.com/gogo/protobuf v1.3.1 // indirect
+	github.com/golang/snappy v0.0.1 // indirect
+	github.com/gorilla/websocket v1.4.1 // indirect
+	github.com/jinzhu/copier v0.1.0 // indirect
+	github.com/jpillora/backoff v1.0.0 // indirect
+	github.com/klauspost/compress v1.8.2 // indirect
+	github.com/klauspost/cpuid v1.2.1 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.3 // indirect
+	github.com/mholt/archiver v3.1.1+incompatible // indirect
+	github.com/nwaples/rardecode v1.1.0 // indirect
+	github.com/opencontainers/go-digest v1.0.0 // indirect
+	github.com/opencontainers/image-spec v1.0.1 // indirect
+	github.com/pierrec/lz4 v2.5.2+incompatible // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/savsgio/gotils v0.0.0-20200117113501-90175b0fbe3f // indirect
+	github.com/sirupsen/logrus v1.6.0 // indirect
+	github.com/ulikunitz/xz v0.5.7 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
+	go.uber.org/atomic v1.6.0 // indirect
+	go.uber.org/multierr v1.5.0 // indirect
+	go.uber.org/zap v1.16.0 // indirect
+	golang.org/x/net v0.0.0-20200625001655-4c5254603344 // indirect
+	golang.org/x/text v0.3.6 // indirect
+	golang.org/x/tools v0.0.0-20191205225056-3393d29bb9fe // indirect
+	google.golang.org/genproto v0.0.0-20190819201941-24fa4b261c55 // indirect
+	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
+	gopkg.in/tomb.v2 v2.0.0-20161208151619-d5d1b5820637 // indirect
+	gopkg.in/validator.v2 v2.0.0-20191107172027-c3144fdedc21 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
+	k8s.io/apimachinery v0.0.0-20190817020851-f2f3a405f61d // indirect
+	k8s.io/klog v0.3.1 // indirect
+)
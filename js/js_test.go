@@ -0,0 +1,189 @@
+package js
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-function/v2/errdetail"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const script = `
+exports.callback = (event, context, callback) => {
+    context.handled = 'callback';
+    callback(null, {name: context.functionName, event: event});
+};
+
+exports.sync = (event, context) => event;
+
+exports.async = (event, context) => Promise.resolve(event.value).then(value => value * 2);
+
+exports.remaining = (event, context) => ({
+    remaining: context.getRemainingTimeInMillis() > 0,
+    cancelled: context.isCancelled()
+});
+
+exports.raw = (event, context) => new Uint8Array([104, 105]).buffer;
+
+exports.throw = (event, context) => {
+    const e = new Error('try again');
+    e.retryable = true;
+    throw e;
+};
+
+exports.reject = (event, context) => {
+    const e = new Error('too slow');
+    e.name = 'TimeoutError';
+    return Promise.reject(e);
+};
+
+exports.loop = (event, context) => {
+    for (;;) {}
+};
+
+exports.require = (event, context) => require('fs');
+
+exports.pending = (event, context) => new Promise(() => {});
+`
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "index.js"), []byte(script), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bad.js"), []byte("exports.handler = ("), 0644))
+
+	cases := map[string]string{
+		"handler":        "config invalid, the handler (handler) of function (f) isn't 'module.export'",
+		"index.missing":  "failed to load function (f): the handler (missing) isn't exported",
+		"missing.handle": "failed to load function (f): open " + filepath.Join(dir, "missing.js") + ": no such file or directory",
+	}
+	for handler, msg := range cases {
+		_, err := NewService(ServiceConfig{Service: "s", CodePath: dir, Functions: []FunctionConfig{{Name: "f", Handler: handler}}})
+		assert.EqualError(t, err, msg, handler)
+	}
+
+	_, err := NewService(ServiceConfig{Service: "s", CodePath: dir, Functions: []FunctionConfig{{Name: "f", Handler: "bad.handler"}}})
+	assert.Error(t, err)
+}
+
+func TestService(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "index.js"), []byte(script), 0644))
+
+	var fcs []FunctionConfig
+	for _, name := range []string{"callback", "sync", "async", "remaining", "raw", "throw", "reject", "loop", "require", "pending"} {
+		fcs = append(fcs, FunctionConfig{Name: name, Handler: "index." + name})
+	}
+	svc, err := NewService(ServiceConfig{Service: "s", CodePath: dir, Timeout: 200 * time.Millisecond, Functions: fcs})
+	assert.NoError(t, err)
+
+	call := func(function, batch, payload string) (*baetyl.Message, error) {
+		return svc.Call(context.Background(), &baetyl.Message{
+			ID:       1,
+			Metadata: map[string]string{"functionName": function, "batch": batch},
+			Payload:  []byte(payload),
+		})
+	}
+	detailOf := func(err error) *structpb.Struct {
+		st, ok := status.FromError(err)
+		assert.True(t, ok)
+		assert.Len(t, st.Details(), 1)
+		return st.Details()[0].(*structpb.Struct)
+	}
+
+	res, err := call("callback", "", `{"a":1}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"callback","event":{"a":1}}`, string(res.Payload))
+	assert.Equal(t, "callback", res.Metadata["handled"])
+	assert.Equal(t, uint64(1), res.ID)
+
+	// the first function is used if the function name is empty
+	res, err = call("", "", "text")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"","event":"text"}`, string(res.Payload))
+
+	res, err = call("sync", "", "")
+	assert.NoError(t, err)
+	assert.Empty(t, res.Payload)
+
+	res, err = call("async", "", `{"value":21}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "42", string(res.Payload))
+
+	res, err = call("remaining", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"remaining":true,"cancelled":false}`, string(res.Payload))
+
+	res, err = call("raw", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", string(res.Payload))
+
+	res, err = call("sync", "true", `[{"a":1},"text","{\"b\":2}",""]`)
+	assert.NoError(t, err)
+	assert.Equal(t, `[{"a":1},"text",{"b":2},null]`, string(res.Payload))
+
+	res, err = call("raw", "true", `[1]`)
+	assert.NoError(t, err)
+	assert.Equal(t, `["hi"]`, string(res.Payload))
+
+	_, err = call("sync", "true", `{}`)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, errdetail.ReasonBadInput, detailOf(err).Fields["reason"].GetStringValue())
+
+	_, err = call("missing", "", "")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, errdetail.ReasonFunctionNotFound, detailOf(err).Fields["reason"].GetStringValue())
+
+	_, err = call("throw", "", "")
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.Equal(t, "[UserCodeInvoke]: Error: try again", status.Convert(err).Message())
+	assert.Equal(t, errdetail.ReasonUserCode, detailOf(err).Fields["reason"].GetStringValue())
+	assert.True(t, detailOf(err).Fields["retryable"].GetBoolValue())
+
+	_, err = call("reject", "", "")
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Equal(t, errdetail.ReasonTimeout, detailOf(err).Fields["reason"].GetStringValue())
+
+	start := time.Now()
+	_, err = call("loop", "", "")
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Equal(t, errdetail.ReasonTimeout, detailOf(err).Fields["reason"].GetStringValue())
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	_, err = call("require", "", "")
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "require is not supported")
+
+	_, err = call("pending", "", "")
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.Equal(t, "[UserCodeReturn]: the promise is never settled", status.Convert(err).Message())
+
+	// the call is interrupted once the caller cancels
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = svc.Call(ctx, &baetyl.Message{Metadata: map[string]string{"functionName": "loop"}})
+	assert.Equal(t, codes.Canceled, status.Code(err))
+}
+
+func TestMemory(t *testing.T) {
+	dir := t.TempDir()
+	src := `exports.handler = (event) => { if (event) { return event; } const a = []; for (;;) { a.push({i: a.length, s: 'x' + a.length}); } };`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "index.js"), []byte(src), 0644))
+
+	svc, err := NewService(ServiceConfig{Service: "s", CodePath: dir, Memory: 8 * 1024 * 1024, Functions: []FunctionConfig{{Name: "f", Handler: "index.handler"}}})
+	assert.NoError(t, err)
+	_, err = svc.Call(context.Background(), &baetyl.Message{Metadata: map[string]string{}})
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.Equal(t, "[UserCodeInvoke]: memory limit exceeded", status.Convert(err).Message())
+
+	// the slot is released for the next call
+	res, err := svc.Call(context.Background(), &baetyl.Message{Metadata: map[string]string{}, Payload: []byte(`"ok"`)})
+	assert.NoError(t, err)
+	assert.Equal(t, `"ok"`, string(res.Payload))
+}
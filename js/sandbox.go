@@ -0,0 +1,371 @@
+package js
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"runtime/metrics"
+	"time"
+
+	"github.com/baetyl/baetyl-function/v2/errdetail"
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/dop251/goja"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// the max depth of the call stack of scripts
+	maxCallStackSize = 1024
+	// how often the heap is sampled to enforce the memory limit during a call
+	heapSampleInterval = 10 * time.Millisecond
+)
+
+var errMemoryExceeded = errors.New("memory limit exceeded")
+
+// slot is held by the running call, the calls run one by one so that the growth of the heap belongs to the call
+var slot = make(chan struct{}, 1)
+
+// heapObjects returns the bytes of the objects in the heap without stopping the world
+func heapObjects() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// sandbox runs a call in a new javascript vm, which has no access to the host except the context and console
+type sandbox struct {
+	ctx  context.Context
+	vm   *goja.Runtime
+	fn   *function
+	done chan struct{}
+	log  *log.Logger
+}
+
+// newSandbox waits for the slot until ctx is done, the script is interrupted once the heap grows by memory
+func newSandbox(ctx context.Context, fn *function, memory int64, logger *log.Logger) (*sandbox, error) {
+	logger = logger.With(log.Any("function", fn.name))
+	select {
+	case slot <- struct{}{}:
+	case <-ctx.Done():
+		return nil, contextError(ctx, logger)
+	}
+	vm := goja.New()
+	vm.SetMaxCallStackSize(maxCallStackSize)
+	sb := &sandbox{ctx: ctx, vm: vm, fn: fn, done: make(chan struct{}), log: logger}
+	sb.setConsole()
+	go sb.watch(heapObjects(), memory)
+	return sb, nil
+}
+
+// watch interrupts the script once ctx is done or the heap grows beyond the memory limit during the call,
+// the garbage is collected before the interruption so that only the live objects are counted
+func (sb *sandbox) watch(base uint64, memory int64) {
+	exceeded := func() bool {
+		heap := heapObjects()
+		return heap > base && int64(heap-base) > memory
+	}
+	ticker := time.NewTicker(heapSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sb.done:
+			return
+		case <-sb.ctx.Done():
+			sb.vm.Interrupt(sb.ctx.Err())
+			return
+		case <-ticker.C:
+			if !exceeded() {
+				continue
+			}
+			runtime.GC()
+			if exceeded() {
+				sb.vm.Interrupt(errMemoryExceeded)
+				return
+			}
+		}
+	}
+}
+
+// close stops watching and releases the slot for the next call
+func (sb *sandbox) close() {
+	close(sb.done)
+	<-slot
+}
+
+func (sb *sandbox) setConsole() {
+	console := sb.vm.NewObject()
+	write := func(fn func(msg string, fields ...log.Field)) func(goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			msg := ""
+			for i, arg := range call.Arguments {
+				if i > 0 {
+					msg += " "
+				}
+				msg += arg.String()
+			}
+			fn(msg)
+			return goja.Undefined()
+		}
+	}
+	console.Set("log", write(sb.log.Info))
+	console.Set("info", write(sb.log.Info))
+	console.Set("debug", write(sb.log.Debug))
+	console.Set("warn", write(sb.log.Warn))
+	console.Set("error", write(sb.log.Error))
+	sb.vm.Set("console", console)
+}
+
+// load runs the module and returns the exported handler
+func (sb *sandbox) load() (goja.Callable, error) {
+	wrapper, err := sb.vm.RunProgram(sb.fn.program)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := goja.AssertFunction(wrapper)
+	if !ok {
+		return nil, errors.New("the module isn't a function")
+	}
+	module, exports := sb.vm.NewObject(), sb.vm.NewObject()
+	module.Set("exports", exports)
+	require := func(goja.FunctionCall) goja.Value {
+		panic(sb.vm.NewGoError(errors.New("require is not supported")))
+	}
+	if _, err = fn(goja.Undefined(), exports, sb.vm.ToValue(require), module); err != nil {
+		return nil, err
+	}
+	handler, ok := goja.AssertFunction(module.Get("exports").ToObject(sb.vm).Get(sb.fn.export))
+	if !ok {
+		return nil, errors.Errorf("the handler (%s) isn't exported", sb.fn.export)
+	}
+	return handler, nil
+}
+
+// run invokes the handler with the payload and the metadata, the string values set in the context are
+// written back to the metadata. In batch mode, the handler is invoked for each event in the json array.
+func (sb *sandbox) run(payload []byte, metadata map[string]string) ([]byte, error) {
+	handler, err := sb.load()
+	if err != nil {
+		return nil, sb.callError(err)
+	}
+	ctx := sb.context(metadata)
+
+	if metadata["batch"] != "true" {
+		result, err := sb.invoke(handler, sb.event(payload), ctx)
+		if err != nil {
+			return nil, err
+		}
+		sb.writeBack(ctx, metadata)
+		return sb.output(result)
+	}
+
+	var events []json.RawMessage
+	if err = json.Unmarshal(payload, &events); err != nil {
+		return nil, errdetail.New(codes.InvalidArgument, errdetail.ReasonBadInput, "[BatchPayload]: the payload is not a json array", false)
+	}
+	results := make([]json.RawMessage, len(events))
+	for i, e := range events {
+		// non-json payloads are put into batch as strings, they are passed as they are
+		event := sb.event(e)
+		var s string
+		if json.Unmarshal(e, &s) == nil {
+			event = sb.event([]byte(s))
+		}
+		result, err := sb.invoke(handler, event, ctx)
+		if err != nil {
+			return nil, err
+		}
+		out, err := sb.output(result)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = batchResult(out, result)
+	}
+	sb.writeBack(ctx, metadata)
+	b, _ := json.Marshal(results)
+	return b, nil
+}
+
+// event parses the json payload, the raw data is passed as a string
+func (sb *sandbox) event(payload []byte) goja.Value {
+	parse, _ := goja.AssertFunction(sb.vm.Get("JSON").ToObject(sb.vm).Get("parse"))
+	v, err := parse(goja.Undefined(), sb.vm.ToValue(string(payload)))
+	if err != nil {
+		return sb.vm.ToValue(string(payload))
+	}
+	return v
+}
+
+// context returns the context of node runtime, which has the metadata, getRemainingTimeInMillis() and isCancelled()
+func (sb *sandbox) context(metadata map[string]string) *goja.Object {
+	ctx := sb.vm.NewObject()
+	for k, v := range metadata {
+		ctx.Set(k, v)
+	}
+	ctx.DefineDataProperty("getRemainingTimeInMillis", sb.vm.ToValue(func(goja.FunctionCall) goja.Value {
+		deadline, ok := sb.ctx.Deadline()
+		if !ok {
+			return goja.Null()
+		}
+		remaining := time.Until(deadline) / time.Millisecond
+		if remaining < 0 {
+			remaining = 0
+		}
+		return sb.vm.ToValue(int64(remaining))
+	}), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	ctx.DefineDataProperty("isCancelled", sb.vm.ToValue(func(goja.FunctionCall) goja.Value {
+		return sb.vm.ToValue(sb.ctx.Err() != nil)
+	}), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	return ctx
+}
+
+// writeBack copies the string values in the context to the metadata, such as cacheControl
+func (sb *sandbox) writeBack(ctx *goja.Object, metadata map[string]string) {
+	for _, k := range ctx.Keys() {
+		if s, ok := ctx.Get(k).Export().(string); ok {
+			metadata[k] = s
+		}
+	}
+}
+
+// invoke calls the handler with (event, context, callback), the result is passed to the callback,
+// returned directly, or returned as a promise which is settled by the end of the call
+func (sb *sandbox) invoke(handler goja.Callable, event goja.Value, ctx *goja.Object) (goja.Value, error) {
+	var called bool
+	var cbErr, cbResult goja.Value
+	callback := func(call goja.FunctionCall) goja.Value {
+		if !called {
+			called, cbErr, cbResult = true, call.Argument(0), call.Argument(1)
+		}
+		return goja.Undefined()
+	}
+	ret, err := handler(goja.Undefined(), event, ctx, sb.vm.ToValue(callback))
+	if err != nil {
+		return nil, sb.callError(err)
+	}
+	if called {
+		if !goja.IsUndefined(cbErr) && !goja.IsNull(cbErr) {
+			return nil, sb.userError(cbErr)
+		}
+		return cbResult, nil
+	}
+	if then, ok := sb.thenOf(ret); ok {
+		var settled, rejected bool
+		var value goja.Value
+		settle := func(reject bool) goja.Value {
+			return sb.vm.ToValue(func(call goja.FunctionCall) goja.Value {
+				if !settled {
+					settled, rejected, value = true, reject, call.Argument(0)
+				}
+				return goja.Undefined()
+			})
+		}
+		// the jobs of promises are run before then returns, since the sandbox has no timers
+		if _, err = then(ret, settle(false), settle(true)); err != nil {
+			return nil, sb.callError(err)
+		}
+		if !settled {
+			return nil, errdetail.New(codes.Unknown, errdetail.ReasonUserCode, "[UserCodeReturn]: the promise is never settled", false)
+		}
+		if rejected {
+			return nil, sb.userError(value)
+		}
+		return value, nil
+	}
+	return ret, nil
+}
+
+// thenOf returns the then method if the value is a promise or a thenable
+func (sb *sandbox) thenOf(v goja.Value) (goja.Callable, bool) {
+	obj, ok := v.(*goja.Object)
+	if !ok {
+		return nil, false
+	}
+	return goja.AssertFunction(obj.Get("then"))
+}
+
+// output returns the payload of the result as the node runtime does: undefined and the empty string are
+// empty, ArrayBuffer is the raw data, and the others are json
+func (sb *sandbox) output(result goja.Value) ([]byte, error) {
+	if result == nil || goja.IsUndefined(result) {
+		return []byte{}, nil
+	}
+	switch v := result.Export().(type) {
+	case string:
+		if v == "" {
+			return []byte{}, nil
+		}
+	case goja.ArrayBuffer:
+		return v.Bytes(), nil
+	}
+	stringify, _ := goja.AssertFunction(sb.vm.Get("JSON").ToObject(sb.vm).Get("stringify"))
+	s, err := stringify(goja.Undefined(), result)
+	if err != nil {
+		return nil, errdetail.New(codes.Unknown, errdetail.ReasonUserCode, "[UserCodeReturn]: "+err.Error(), false)
+	}
+	if goja.IsUndefined(s) {
+		return []byte{}, nil
+	}
+	return []byte(s.String()), nil
+}
+
+// batchResult puts the raw data into the results as a string, and the empty output as null
+func batchResult(out []byte, result goja.Value) json.RawMessage {
+	if len(out) == 0 {
+		return json.RawMessage("null")
+	}
+	if _, ok := result.Export().(goja.ArrayBuffer); ok {
+		b, _ := json.Marshal(string(out))
+		return b
+	}
+	return out
+}
+
+// callError maps the failure of running the script, which is thrown by the script or interrupted by the sandbox
+func (sb *sandbox) callError(err error) error {
+	switch e := err.(type) {
+	case *goja.Exception:
+		return sb.userError(e.Value())
+	case *goja.InterruptedError:
+		switch e.Value() {
+		case context.DeadlineExceeded, context.Canceled:
+			return contextError(sb.ctx, sb.log)
+		}
+	}
+	sb.log.Error("error when invoking function", log.Error(err))
+	return errdetail.New(codes.Unknown, errdetail.ReasonUserCode, "[UserCodeInvoke]: "+errorMessage(err), false)
+}
+
+// contextError maps the error of ctx which is done before or during the call
+func contextError(ctx context.Context, logger *log.Logger) error {
+	if ctx.Err() == context.Canceled {
+		return status.Error(codes.Canceled, "the call is cancelled")
+	}
+	logger.Error("the call times out")
+	return errdetail.New(codes.DeadlineExceeded, errdetail.ReasonTimeout, "the call times out", false)
+}
+
+// userError maps the error thrown or returned by the script as the node runtime does
+func (sb *sandbox) userError(v goja.Value) error {
+	message := "[UserCodeInvoke]: " + v.String()
+	sb.log.Error("error when invoking function", log.Any("error", v.String()))
+	if obj, ok := v.(*goja.Object); ok {
+		if name := obj.Get("name"); name != nil && name.String() == "TimeoutError" {
+			return errdetail.New(codes.DeadlineExceeded, errdetail.ReasonTimeout, message, false)
+		}
+		retryable := obj.Get("retryable")
+		return errdetail.New(codes.Unknown, errdetail.ReasonUserCode, message, retryable != nil && retryable.StrictEquals(sb.vm.ToValue(true)))
+	}
+	return errdetail.New(codes.Unknown, errdetail.ReasonUserCode, message, false)
+}
+
+// errorMessage drops the location from the message of interruption
+func errorMessage(err error) string {
+	if e, ok := err.(*goja.InterruptedError); ok {
+		if v, ok := e.Value().(error); ok {
+			return v.Error()
+		}
+	}
+	return err.Error()
+}
@@ -0,0 +1,157 @@
+package js
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-function/v2/errdetail"
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/dop251/goja"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	defaultCodePath = "var/lib/baetyl/code"
+	defaultTimeout  = 30 * time.Second
+	defaultMemory   = 64 * 1024 * 1024
+)
+
+// ServiceConfig the service whose javascript functions are run in process, the functions are loaded from the
+// code path in the same format as the node runtime
+type ServiceConfig struct {
+	Service   string           `yaml:"service" json:"service" validate:"nonzero"`
+	CodePath  string           `yaml:"codePath" json:"codePath" default:"var/lib/baetyl/code"`
+	Timeout   time.Duration    `yaml:"timeout" json:"timeout" default:"30s"`
+	Memory    int64            `yaml:"memory" json:"memory" default:"67108864"`
+	Functions []FunctionConfig `yaml:"functions" json:"functions"`
+}
+
+// FunctionConfig the function whose handler is 'module.export', the module is the script in the code dir
+// without the suffix '.js'
+type FunctionConfig struct {
+	Name    string `yaml:"name" json:"name" validate:"nonzero"`
+	Handler string `yaml:"handler" json:"handler" validate:"nonzero"`
+	CodeDir string `yaml:"codedir" json:"codedir"`
+}
+
+type function struct {
+	name    string
+	export  string
+	program *goja.Program
+}
+
+// Service the javascript functions of a service, every call runs in a new sandbox
+type Service struct {
+	cfg       ServiceConfig
+	names     []string
+	functions map[string]*function
+	log       *log.Logger
+}
+
+// NewService compiles the scripts of the functions
+func NewService(cfg ServiceConfig) (*Service, error) {
+	if cfg.CodePath == "" {
+		cfg.CodePath = defaultCodePath
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.Memory <= 0 {
+		cfg.Memory = defaultMemory
+	}
+	s := &Service{
+		cfg:       cfg,
+		functions: map[string]*function{},
+		log:       log.With(log.Any("js", cfg.Service)),
+	}
+	programs := map[string]*goja.Program{}
+	for _, fc := range cfg.Functions {
+		if fc.Name == "" || fc.Handler == "" {
+			return nil, errors.New("config invalid, missing function name or handler")
+		}
+		i := strings.LastIndex(fc.Handler, ".")
+		if i <= 0 || i == len(fc.Handler)-1 {
+			return nil, errors.Errorf("config invalid, the handler (%s) of function (%s) isn't 'module.export'", fc.Handler, fc.Name)
+		}
+		file := filepath.Join(cfg.CodePath, fc.CodeDir, fc.Handler[:i]+".js")
+		program, ok := programs[file]
+		if !ok {
+			var err error
+			program, err = compile(file)
+			if err != nil {
+				return nil, errors.Errorf("failed to load function (%s): %s", fc.Name, err.Error())
+			}
+			programs[file] = program
+		}
+		fn := &function{name: fc.Name, export: fc.Handler[i+1:], program: program}
+		if err := s.check(fn); err != nil {
+			return nil, errors.Errorf("failed to load function (%s): %s", fc.Name, err.Error())
+		}
+		s.names = append(s.names, fc.Name)
+		s.functions[fc.Name] = fn
+	}
+	return s, nil
+}
+
+// check runs the module in a sandbox to make sure the handler is exported
+func (s *Service) check(fn *function) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+	sb, err := newSandbox(ctx, fn, s.cfg.Memory, s.log)
+	if err != nil {
+		return err
+	}
+	defer sb.close()
+	_, err = sb.load()
+	return err
+}
+
+// compile wraps the script as a commonjs module
+func compile(file string) (*goja.Program, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	program, err := goja.Compile(file, "(function (exports, require, module) {"+string(src)+"\n})", false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return program, nil
+}
+
+// Call invokes the function chosen by functionName with (event, context, callback) as the node runtime does,
+// the first function is chosen if functionName is empty. It has the signature of function.Handler.
+func (s *Service) Call(ctx context.Context, msg *baetyl.Message) (*baetyl.Message, error) {
+	name := msg.Metadata["functionName"]
+	if name == "" && len(s.names) > 0 {
+		name = s.names[0]
+	}
+	fn, ok := s.functions[name]
+	if !ok {
+		s.log.Error("the function doesn't found", log.Any("function", name))
+		return nil, errdetail.New(codes.NotFound, errdetail.ReasonFunctionNotFound, "the function doesn't found: "+name, false)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	sb, err := newSandbox(ctx, fn, s.cfg.Memory, s.log)
+	if err != nil {
+		return nil, err
+	}
+	defer sb.close()
+
+	metadata := map[string]string{}
+	for k, v := range msg.Metadata {
+		metadata[k] = v
+	}
+	payload, err := sb.run(msg.Payload, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &baetyl.Message{ID: msg.ID, Metadata: metadata, Payload: payload}, nil
+}
@@ -4,10 +4,19 @@ const path = require('path');
 const fs = require('fs');
 const log4js = require('log4js');
 const moment = require('moment');
-const grpc = require('grpc');
+const grpc = require('@grpc/grpc-js');
 const yaml = require('yaml');
+const jspb = require('google-protobuf');
+const { Any } = require('google-protobuf/google/protobuf/any_pb.js');
+const { Struct } = require('google-protobuf/google/protobuf/struct_pb.js');
 const services = require('./function_grpc_pb.js');
 
+// the reasons of the structured errors reported to the proxy in the details of grpc status
+const REASON_USER_CODE = 'USER_CODE_ERROR';
+const REASON_FUNCTION_NOT_FOUND = 'FUNCTION_NOT_FOUND';
+const REASON_BAD_INPUT = 'BAD_INPUT';
+const REASON_TIMEOUT = 'TIMEOUT';
+
 const hasAttr = (obj, attr) => {
     if (obj instanceof Object && !(obj instanceof Array)) {
         if (obj[attr] !== undefined) {
@@ -79,6 +88,104 @@ const getFunctions = s => {
     return functionsHandle;
 };
 
+const getBatchFunctions = s => {
+    let batchFunctions = new Set();
+    if (!hasAttr(s.config, 'functions')) {
+        return batchFunctions;
+    }
+    s.config.functions.forEach(function (ele) {
+        if (ele.batch === true) {
+            batchFunctions.add(ele.name);
+        }
+    });
+    return batchFunctions;
+};
+
+// non-json payloads are put into batch as strings, pass them to handler as raw data
+const batchEvent = event => (typeof event === 'string' ? Buffer.from(event) : event);
+
+// raw data results are returned in batch as strings
+const batchResult = result => {
+    if (Buffer.isBuffer(result)) {
+        return result.toString();
+    }
+    return result === undefined ? null : result;
+};
+
+// invoke calls the handler with (event, context, callback), the handler can also be async or return
+// a promise, whose result is passed to the callback. Only the first result of the handler is taken.
+const invoke = (handler, event, ctx, callback) => {
+    let settled = false;
+    const once = (err, result) => {
+        if (settled) {
+            return;
+        }
+        settled = true;
+        callback(err, result);
+    };
+    const ret = handler(event, ctx, once);
+    if (ret instanceof Object && typeof ret.then === 'function') {
+        ret.then(result => once(null, result), err => {
+            once(err == null ? new Error('the promise is rejected without a reason') : err);
+        });
+    }
+};
+
+// functions get the remaining time in milliseconds before the deadline of the invocation
+// by ctx.getRemainingTimeInMillis(), which returns null if there is no deadline
+const withRemainingTime = ctx => {
+    Object.defineProperty(ctx, 'getRemainingTimeInMillis', {
+        enumerable: false,
+        value: () => {
+            if (!hasAttr(ctx, 'deadline')) {
+                return null;
+            }
+            return Math.max(Number(ctx['deadline']) - Date.now(), 0);
+        }
+    });
+    return ctx;
+};
+
+// functions check ctx.isCancelled() to stop early once the invocation is cancelled, such as
+// the client disconnects or the deadline exceeds, the result of a cancelled invocation is thrown away
+const withCancellation = (ctx, call) => {
+    Object.defineProperty(ctx, 'isCancelled', {
+        enumerable: false,
+        value: () => call.cancelled === true
+    });
+    return ctx;
+};
+
+// newError returns the error with the structured detail, which is a google.protobuf.Struct
+// in the details of grpc status, i.e. the google.rpc.Status in the trailer 'grpc-status-details-bin'
+const newError = (code, reason, message, retryable) => {
+    const detail = Struct.fromJavaScript({ reason, message, retryable: retryable === true });
+    const any = new Any();
+    any.pack(detail.serializeBinary(), 'google.protobuf.Struct');
+
+    const writer = new jspb.BinaryWriter();
+    writer.writeInt32(1, code);
+    writer.writeString(2, message);
+    writer.writeMessage(3, any, Any.serializeBinaryToWriter);
+
+    const metadata = new grpc.Metadata();
+    metadata.set('grpc-status-details-bin', Buffer.from(writer.getResultBuffer()));
+    const err = new Error(message);
+    err.code = code;
+    err.metadata = metadata;
+    return err;
+};
+
+// userCodeError maps the error returned by function, the error with name 'TimeoutError'
+// is reported as timeout, and the function can return an error with property 'retryable' to let the proxy retry
+const userCodeError = (prefix, err) => {
+    const message = prefix + err.toString();
+    if (err instanceof Object && err.name === 'TimeoutError') {
+        return newError(grpc.status.DEADLINE_EXCEEDED, REASON_TIMEOUT, message);
+    }
+    return newError(grpc.status.UNKNOWN, REASON_USER_CODE, message, err instanceof Object && err.retryable === true);
+};
+
 const getGrpcServer = s => {
     let config = {
         'address': s.serverAddress,
@@ -117,13 +224,12 @@ const getGrpcServer = s => {
         credentials = grpc.ServerCredentials.createInsecure();
     }
 
-    server.bind(config['address'], credentials);
-    return server;
+    return { server, address: config['address'], credentials };
 };
 
 class NodeRuntimeModule {
     constructor() {
-        this.name = 'baetyl-node10';
+        this.name = 'baetyl-node';
         this.confPath = 'etc/baetyl/conf.yml';
         this.codePath = 'var/lib/baetyl/code';
         this.serverAddress = "0.0.0.0:80";
@@ -169,15 +275,25 @@ class NodeRuntimeModule {
         
         this.logger = getLogger(this);
         this.functionsHandle = getFunctions(this);
-        this.server = getGrpcServer(this);
+        this.batchFunctions = getBatchFunctions(this);
+        const { server, address, credentials } = getGrpcServer(this);
+        this.server = server;
+        this.address = address;
+        this.credentials = credentials;
 
         this.server.addService(services.FunctionService, {
             call: (call, callback) => (this.Call(call, callback))
         });
     }
+    // the server serves once it is bound
     Start() {
         this.logger.info('service starting');
-        this.server.start();
+        this.server.bindAsync(this.address, this.credentials, err => {
+            if (err) {
+                this.logger.error('failed to bind %s: %s', this.address, err.toString());
+                return log4js.shutdown(() => process.exit(1));
+            }
+        });
     }
     Close(callback) {
         if (hasAttr(this.config.server, 'timeout')) {
@@ -197,20 +313,30 @@ class NodeRuntimeModule {
         if (!functionName) {
             if (Object.keys(this.functionsHandle).length < 1) {
                 this.logger.error("no functions exist");
-                return callback(new Error("no functions exist"));
+                return callback(newError(grpc.status.NOT_FOUND, REASON_FUNCTION_NOT_FOUND, "no functions exist"));
             }
             functionName = Object.keys(this.functionsHandle)[0]
         }
 
         if (!hasAttr(this.functionsHandle, functionName)) {
             this.logger.error("the function doesn't found: %s", functionName);
-            return callback(new Error("the function doesn't found"));
+            return callback(newError(grpc.status.NOT_FOUND, REASON_FUNCTION_NOT_FOUND,
+                "the function doesn't found: " + functionName));
         }
 
         let ctx = {};
         call.request.getMetadataMap().forEach(function (v, k) {
             ctx[k] = v
         });
+        withRemainingTime(ctx);
+        withCancellation(ctx, call);
+        call.on('cancelled', () => {
+            this.logger.warn("invocation %s is cancelled", ctx['invokeId']);
+        });
+
+        if (ctx['batch'] === 'true') {
+            return this.CallBatch(call, callback, functionName, ctx);
+        }
 
         let msg = '';
         const Payload = call.request.getPayload();
@@ -223,15 +349,27 @@ class NodeRuntimeModule {
 
         let functionHandle = this.functionsHandle[functionName];
         try {
-            functionHandle(
+            invoke(
+                functionHandle,
                 msg,
                 ctx,
                 (err, respMsg) => {
+                    if (ctx.isCancelled()) {
+                        this.logger.warn("invocation %s is cancelled, the result is thrown away", ctx['invokeId']);
+                        return;
+                    }
                     if (err != null) {
                         this.logger.error("error when invoking function %s: %s" , functionName, err.toString());
-                        return callback(new Error("[UserCodeInvoke]: " + err.toString()));
+                        return callback(userCodeError("[UserCodeInvoke]: ", err));
                     }
 
+                    // functions can set string values in context to pass them back, such as cacheControl
+                    Object.keys(ctx).forEach(k => {
+                        if (typeof ctx[k] === 'string') {
+                            call.request.getMetadataMap().set(k, ctx[k]);
+                        }
+                    });
+
                     if (respMsg === "" || respMsg === undefined) {
                         call.request.setPayload("");
                     } else if (Buffer.isBuffer(respMsg)) {
@@ -243,14 +381,90 @@ class NodeRuntimeModule {
                             call.request.setPayload(Buffer.from(jsonString));
                         }
                         catch (error) {
-                            return callback(new Error("[UserCodeReturn]: " + error.toString()));
+                            return callback(newError(grpc.status.UNKNOWN, REASON_USER_CODE, "[UserCodeReturn]: " + error.toString()));
                         }
                     }
                     callback(null, call.request);
                 })
         } catch(e) {
             this.logger.error("error when invoking function %s: %s" , functionName, e.toString());
-            return callback(new Error("[UserCodeInvoke]: " + e.toString()));
+            return callback(userCodeError("[UserCodeInvoke]: ", e));
+        }
+    }
+    // the payload of batch request is a json array of events, and a json array
+    // of results is returned in the same order
+    CallBatch(call, callback, functionName, ctx) {
+        let events = [];
+        try {
+            events = JSON.parse(Buffer.from(call.request.getPayload()).toString());
+        } catch (error) {
+            this.logger.error("invalid batch payload: %s", error.toString());
+            return callback(newError(grpc.status.INVALID_ARGUMENT, REASON_BAD_INPUT, "[BatchPayload]: " + error.toString()));
+        }
+
+        let invokeIds = [];
+        try {
+            invokeIds = JSON.parse(ctx['batchInvokeIds'] || '[]');
+        } catch (error) {
+            invokeIds = [];
+        }
+        const contexts = events.map((event, i) => {
+            let c = Object.assign({}, ctx);
+            if (i < invokeIds.length) {
+                c['invokeId'] = invokeIds[i];
+            }
+            return withCancellation(withRemainingTime(c), call);
+        });
+
+        const done = (err, results) => {
+            if (ctx.isCancelled()) {
+                this.logger.warn("invocation %s is cancelled, the result is thrown away", ctx['invokeId']);
+                return;
+            }
+            if (err != null) {
+                this.logger.error("error when invoking function %s: %s" , functionName, err.toString());
+                return callback(userCodeError("[UserCodeInvoke]: ", err));
+            }
+            try {
+                const jsonString = JSON.stringify(results.map(batchResult));
+                call.request.setPayload(Buffer.from(jsonString));
+            }
+            catch (error) {
+                return callback(newError(grpc.status.UNKNOWN, REASON_USER_CODE, "[UserCodeReturn]: " + error.toString()));
+            }
+            callback(null, call.request);
+        };
+
+        let functionHandle = this.functionsHandle[functionName];
+        try {
+            if (this.batchFunctions.has(functionName)) {
+                return invoke(functionHandle, events, contexts, done);
+            }
+
+            let results = new Array(events.length);
+            let pending = events.length;
+            let failed = false;
+            if (pending === 0) {
+                return done(null, results);
+            }
+            events.forEach((event, i) => {
+                invoke(functionHandle, batchEvent(event), contexts[i], (err, respMsg) => {
+                    if (failed) {
+                        return;
+                    }
+                    if (err != null) {
+                        failed = true;
+                        return done(err);
+                    }
+                    results[i] = respMsg;
+                    if (--pending === 0) {
+                        done(null, results);
+                    }
+                });
+            });
+        } catch(e) {
+            this.logger.error("error when invoking function %s: %s" , functionName, e.toString());
+            return callback(userCodeError("[UserCodeInvoke]: ", e));
         }
     }
 }
@@ -4,7 +4,9 @@
 python3 runtime
 """
 
+import asyncio
 import importlib
+import inspect
 import os
 import sys
 import time
@@ -12,14 +14,90 @@ import grpc
 import yaml
 import json
 import signal
+import threading
 from concurrent import futures
 import function_pb2
 import function_pb2_grpc
+from google.protobuf import any_pb2
+from google.protobuf import struct_pb2
+from google.rpc import status_pb2
+from grpc_status import rpc_status
 import logging
 import logging.handlers
 from urllib import parse
 
 _ONE_DAY_IN_SECONDS = 60 * 60 * 24
+_RELOAD_INTERVAL_IN_SECONDS = 1
+
+# the reasons of the structured errors reported to the proxy in the details of grpc status
+REASON_USER_CODE = 'USER_CODE_ERROR'
+REASON_FUNCTION_NOT_FOUND = 'FUNCTION_NOT_FOUND'
+REASON_BAD_INPUT = 'BAD_INPUT'
+REASON_TIMEOUT = 'TIMEOUT'
+REASON_RESOURCE_EXHAUSTED = 'RESOURCE_EXHAUSTED'
+
+
+class Context(dict):
+    """
+    context of function invocation, the metadata of the request are its items
+    """
+
+    def get_remaining_time_in_millis(self):
+        """
+        get the remaining time in milliseconds before the deadline of the invocation,
+        None is returned if there is no deadline
+        """
+        if 'deadline' not in self:
+            return None
+        return max(int(self['deadline']) - int(time.time() * 1000), 0)
+
+    def is_cancelled(self):
+        """
+        whether the invocation is cancelled, such as the client disconnects or the deadline
+        exceeds, the result of a cancelled invocation is thrown away
+        """
+        cancelled = getattr(self, '_cancelled', None)
+        return cancelled is not None and cancelled.is_set()
+
+    def bind(self, context):
+        """
+        mark the invocation cancelled once the rpc terminates, the rpc terminating before
+        the function returns is cancelled
+        """
+        self._cancelled = threading.Event()
+        self._rpc = context
+        context.add_callback(self._cancelled.set)
+        return self
+
+    def on_cancel(self, callback):
+        """
+        call the callback once the invocation is cancelled, or at once if it is already cancelled
+        """
+        rpc = getattr(self, '_rpc', None)
+        if rpc is not None and not rpc.add_callback(callback):
+            callback()
+
+
+class Limit(threading.BoundedSemaphore):
+    """
+    the semaphore limiting the concurrent invocations of a function
+    """
+
+    def __init__(self, concurrency):
+        super(Limit, self).__init__(concurrency)
+        self.concurrency = concurrency
+
+
+class Functions(object):
+    """
+    the handlers of functions with their batch flags and concurrency limits, which are swapped
+    as a whole when reloading, so that a call always sees the function of the same version
+    """
+
+    def __init__(self, config, code_path, old=None):
+        self.handlers = get_functions(config, code_path)
+        self.batch = get_batch_functions(config)
+        self.limits = get_function_limits(config, None if old is None else old.limits)
 
 
 class mo(function_pb2_grpc.FunctionServicer):
@@ -62,14 +140,11 @@ class mo(function_pb2_grpc.FunctionServicer):
         if os.environ['BAETYL_RUN_MODE'] == 'native':
             self.server_address = "127.0.0.1:" + os.environ['BAETYL_SERVICE_DYNAMIC_PORT']
 
-        self.config = {}
-
-        if os.path.exists(self.conf_path):
-            self.config = yaml.load(
-                open(self.conf_path, 'r').read(), Loader=yaml.FullLoader)
-
+        self.config = load_config(self.conf_path)
         self.log = get_logger(self)
-        self.functions = get_functions(self)
+        self.functions = Functions(self.config, self.code_path)
+        self.reload_lock = threading.Lock()
+        self.loop = get_event_loop()
         self.server = get_grpc_server(self)
         function_pb2_grpc.add_FunctionServicer_to_server(self, self.server)
 
@@ -79,6 +154,8 @@ class mo(function_pb2_grpc.FunctionServicer):
         """
         self.log.info("service starting")
         self.server.start()
+        if self.config.get('reload', {}).get('enable', True):
+            start_reloader(self)
 
     def Close(self):
         """
@@ -89,38 +166,93 @@ class mo(function_pb2_grpc.FunctionServicer):
             if 'timeout' in self.config['server']:
                 grace = self.config['server']['timeout'] / 1e9
         self.server.stop(grace)
+        self.loop.call_soon_threadsafe(self.loop.stop)
         self.log.info("service closed")
 
+    def Reload(self):
+        """
+        reload the config and the code of functions, the calls in progress finish on the old version,
+        and the old version is kept if the new one fails to load
+        """
+        with self.reload_lock:
+            try:
+                config = load_config(self.conf_path)
+                purged = purge_modules(self.code_path)
+                try:
+                    functions = Functions(config, self.code_path, self.functions)
+                except BaseException:
+                    purge_modules(self.code_path)
+                    sys.modules.update(purged)
+                    raise
+            except BaseException as err:
+                self.log.error("failed to reload functions, keep the old version: %s", err, exc_info=True)
+                return
+            for k in ('server', 'logger'):
+                if config.get(k) != self.config.get(k):
+                    self.log.warning("the changes of %s take effect after restart", k)
+            self.functions = functions
+            self.log.info("functions reloaded: %s", ', '.join(functions.handlers.keys()))
+
     def Call(self, request, context):
         """
         call request
         """
+        functions = self.functions
         function = request.Metadata['functionName']
         if function == "":
-            if len(self.functions) < 1:
+            if len(functions.handlers) < 1:
                 self.log.error("no functions exist")
-                raise Exception("no functions exist")
-            function = list(self.functions.keys())[0]
+                abort(context, grpc.StatusCode.NOT_FOUND, REASON_FUNCTION_NOT_FOUND, "no functions exist")
+            function = list(functions.handlers.keys())[0]
 
-        if function not in self.functions:
+        if function not in functions.handlers:
             self.log.error("the function doesn't found: %s", function)
-            raise Exception("the function doesn't found")
+            abort(context, grpc.StatusCode.NOT_FOUND, REASON_FUNCTION_NOT_FOUND,
+                  "the function doesn't found: " + function)
+
+        limit = functions.limits.get(function)
+        if limit is not None and not limit.acquire(blocking=False):
+            self.log.warning("the function %s reaches the max concurrency", function)
+            abort(context, grpc.StatusCode.RESOURCE_EXHAUSTED, REASON_RESOURCE_EXHAUSTED,
+                  "the function reaches the max concurrency: " + function)
+        try:
+            return self.CallFunction(functions, function, request, context)
+        finally:
+            if limit is not None:
+                limit.release()
 
-        ctx = {}
+    def CallFunction(self, functions, function, request, context):
+        """
+        call the function with the request
+        """
+        ctx = Context()
         for k in request.Metadata.keys():
             ctx[k] = request.Metadata[k]
+        ctx.bind(context)
 
-        msg = b''
-        try:
-            msg = json.loads(request.Payload)
-        except BaseException:
-            msg = request.Payload  # raw data, not json format
+        if ctx.get('batch') == 'true':
+            msg = self.CallBatch(functions, function, request, ctx, context)
+        else:
+            msg = b''
+            try:
+                msg = json.loads(request.Payload)
+            except BaseException:
+                msg = request.Payload  # raw data, not json format
 
-        try:
-            msg = self.functions[function](msg, ctx)
-        except BaseException as err:
-            self.log.error("error when invoking function %s: %s", function, err)
-            raise Exception("[UserCodeInvoke] ", err)
+            try:
+                msg = self.Invoke(functions.handlers[function], ctx, msg, ctx)
+            except BaseException as err:
+                self.log.error("error when invoking function %s: %s", function, err)
+                abort_user_code(context, err)
+
+        if ctx.is_cancelled():
+            self.log.warning("invocation %s is cancelled, the result is thrown away", ctx.get('invokeId'))
+            return function_pb2.Message()
+
+        # functions can set string values in context to pass them back, such as cacheControl
+        for k, v in ctx.items():
+            if isinstance(v, str):
+                request.Metadata[k] = v
 
         if msg is None:
             request.Payload = b''
@@ -131,17 +263,127 @@ class mo(function_pb2_grpc.FunctionServicer):
                 request.Payload = json.dumps(msg).encode('utf-8')
             except BaseException as err:
                 self.log.error(err, exc_info=True)
-                raise Exception("[UserCodeReturn] ", err)
+                abort(context, grpc.StatusCode.UNKNOWN, REASON_USER_CODE, "[UserCodeReturn] " + str(err))
         return request
 
 
-def get_functions(s):
+    def CallBatch(self, functions, function, request, ctx, context):
+        """
+        call batch request, the payload is a json array of events and a json array
+        of results is returned in the same order
+        """
+        try:
+            events = json.loads(request.Payload)
+        except BaseException as err:
+            self.log.error("invalid batch payload: %s", err)
+            abort(context, grpc.StatusCode.INVALID_ARGUMENT, REASON_BAD_INPUT, "[BatchPayload] " + str(err))
+
+        invoke_ids = json.loads(ctx.get('batchInvokeIds', '[]'))
+        contexts = []
+        for i in range(len(events)):
+            c = Context(ctx)
+            if i < len(invoke_ids):
+                c['invokeId'] = invoke_ids[i]
+            contexts.append(c.bind(context))
+
+        handler = functions.handlers[function]
+        try:
+            if function in functions.batch:
+                results = self.Invoke(handler, ctx, events, contexts)
+            else:
+                results = []
+                for e, c in zip(events, contexts):
+                    if ctx.is_cancelled():
+                        break
+                    results.append(self.Invoke(handler, c, batch_event(e), c))
+        except BaseException as err:
+            self.log.error("error when invoking function %s: %s", function, err)
+            abort_user_code(context, err)
+        return [batch_result(r) for r in results]
+
+    def Invoke(self, handler, ctx, *args):
+        """
+        invoke the handler, the coroutine returned by an async handler is run on the shared event loop
+        and waited for in the current thread, it is cancelled once the invocation is cancelled
+        """
+        result = handler(*args)
+        if not inspect.isawaitable(result):
+            return result
+        future = asyncio.run_coroutine_threadsafe(as_coroutine(result), self.loop)
+        ctx.on_cancel(future.cancel)
+        try:
+            return future.result()
+        except futures.CancelledError:
+            return None
+
+
+def abort(context, code, reason, message, retryable=False):
+    """
+    terminate the rpc with the structured error, which is a google.protobuf.Struct
+    in the details of grpc status
+    """
+    detail = struct_pb2.Struct()
+    detail.update({'reason': reason, 'message': message, 'retryable': retryable})
+    any_detail = any_pb2.Any()
+    any_detail.Pack(detail)
+    context.abort_with_status(rpc_status.to_status(status_pb2.Status(
+        code=code.value[0], message=message, details=[any_detail])))
+
+
+def abort_user_code(context, err):
+    """
+    terminate the rpc with the error raised by function, TimeoutError is reported as timeout,
+    and the function can raise an error with attribute 'retryable' to let the proxy retry
+    """
+    if isinstance(err, (TimeoutError, asyncio.TimeoutError)):
+        abort(context, grpc.StatusCode.DEADLINE_EXCEEDED, REASON_TIMEOUT, "[UserCodeInvoke] " + str(err))
+    abort(context, grpc.StatusCode.UNKNOWN, REASON_USER_CODE, "[UserCodeInvoke] " + str(err),
+          bool(getattr(err, 'retryable', False)))
+
+
+async def as_coroutine(awaitable):
+    """
+    wrap the awaitable as a coroutine to run it on the event loop
+    """
+    return await awaitable
+
+
+def batch_event(event):
+    """
+    non-json payloads are put into batch as strings, pass them to handler as raw data
+    """
+    if isinstance(event, str):
+        return event.encode('utf-8')
+    return event
+
+
+def batch_result(result):
+    """
+    raw data results are returned in batch as strings
+    """
+    if isinstance(result, bytes):
+        return result.decode('utf-8')
+    return result
+
+
+def load_config(conf_path):
+    """
+    load the config file, the config is empty if the file doesn't exist
+    """
+    if not os.path.exists(conf_path):
+        return {}
+    with open(conf_path, 'r') as f:
+        return yaml.load(f.read(), Loader=yaml.FullLoader) or {}
+
+
+def get_functions(config, code_path):
     functions_handler = {}
-    if 'functions' not in s.config:
+    if 'functions' not in config:
         return functions_handler
-        
-    sys.path.append(s.code_path)
-    for fc in s.config['functions']:
+
+    if code_path not in sys.path:
+        sys.path.append(code_path)
+    for fc in config['functions']:
         if 'name' not in fc or 'handler' not in fc:
             raise Exception(
                 'config invalid, missing function name or handler')
@@ -155,6 +397,95 @@ def get_functions(s):
         functions_handler[fc['name']] = getattr(module, handler_name)
     return functions_handler
 
+def get_batch_functions(config):
+    """
+    get the names of functions which handle a whole batch at once
+    """
+    if 'functions' not in config:
+        return set()
+    return set(fc['name'] for fc in config['functions'] if fc.get('batch', False))
+
+
+def get_function_limits(config, old=None):
+    """
+    get the semaphores of functions which limit the concurrent invocations by 'concurrency',
+    the old semaphore is kept if the concurrency of the function isn't changed
+    """
+    limits = {}
+    if 'functions' not in config:
+        return limits
+    for fc in config['functions']:
+        concurrency = fc.get('concurrency', 0)
+        if concurrency <= 0:
+            continue
+        limit = None if old is None else old.get(fc['name'])
+        if limit is None or limit.concurrency != concurrency:
+            limit = Limit(concurrency)
+        limits[fc['name']] = limit
+    return limits
+
+
+def purge_modules(code_path):
+    """
+    remove the modules loaded from the code path, so that they are imported again from the
+    changed code, the removed modules are returned to be restored if the import fails
+    """
+    root = os.path.join(os.path.abspath(code_path), '')
+    purged = {}
+    for name, module in list(sys.modules.items()):
+        file = getattr(module, '__file__', None)
+        if file and os.path.abspath(file).startswith(root):
+            purged[name] = sys.modules.pop(name)
+    importlib.invalidate_caches()
+    return purged
+
+
+def snapshot(s):
+    """
+    get the modification time and size of the conf file and the python files in the code path
+    """
+    files = {}
+    paths = [s.conf_path]
+    for root, _, names in os.walk(s.code_path):
+        paths.extend(os.path.join(root, n) for n in names if n.endswith('.py'))
+    for path in paths:
+        try:
+            st = os.stat(path)
+            files[path] = (st.st_mtime, st.st_size)
+        except OSError:
+            continue  # removed while walking
+    return files
+
+
+def start_reloader(s):
+    """
+    watch the conf file and the code path in a daemon thread, and reload the functions once they change
+    """
+    def watch():
+        last = snapshot(s)
+        while True:
+            time.sleep(_RELOAD_INTERVAL_IN_SECONDS)
+            try:
+                current = snapshot(s)
+                if current != last:
+                    last = current
+                    s.log.info("the functions are changed, reloading")
+                    s.Reload()
+            except BaseException as err:
+                s.log.error("failed to watch the functions: %s", err)
+
+    threading.Thread(target=watch, name='reloader', daemon=True).start()
+
+
+def get_event_loop():
+    """
+    get the event loop shared by async handlers, which runs in a daemon thread
+    """
+    loop = asyncio.new_event_loop()
+    threading.Thread(target=loop.run_forever, name='event-loop', daemon=True).start()
+    return loop
+
+
 def get_grpc_server(s):
     """
     get grpc server
@@ -167,7 +498,8 @@ def get_grpc_server(s):
         'cert': s.cert['cert'],
     }
     if 'server' in s.config:
-        c = s.config['server']
+        c = dict(s.config['server'])
+        c.setdefault('address', s.server_address)
 
     max_workers = None
     max_concurrent = None
@@ -178,6 +510,9 @@ def get_grpc_server(s):
     if 'concurrent' in c:
         if 'max' in c['concurrent']:
             max_concurrent = c['concurrent']['max']
+    # the admitted rpcs are handled at once rather than waiting for workers
+    if max_workers is None and max_concurrent is not None:
+        max_workers = max_concurrent
     if 'message' in c:
         if 'length' in c['message']:
             if 'max' in c['message']['length']:
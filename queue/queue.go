@@ -0,0 +1,357 @@
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketQueues = []byte("queues")
+	bucketDedup  = []byte("dedup")
+)
+
+// Config of the durable invocation queue
+type Config struct {
+	Enable      bool          `yaml:"enable" json:"enable"`
+	Path        string        `yaml:"path" json:"path" default:"var/lib/baetyl/function/queue.db"`
+	DedupWindow time.Duration `yaml:"dedupWindow" json:"dedupWindow" default:"1h"`
+	MaxAttempts int           `yaml:"maxAttempts" json:"maxAttempts" default:"10"`
+	Backoff     BackoffConfig `yaml:"backoff" json:"backoff"`
+}
+
+// BackoffConfig the interval between two delivery attempts of the same message
+type BackoffConfig struct {
+	Min time.Duration `yaml:"min" json:"min" default:"1s"`
+	Max time.Duration `yaml:"max" json:"max" default:"1m"`
+}
+
+// Handler delivers queued messages
+type Handler interface {
+	// Deliver delivers the message, the message is kept and delivered again if an error is returned,
+	// unless the error is wrapped by Permanent
+	Deliver(ctx context.Context, msg *baetyl.Message) error
+	// Drop is called when the message is given up after the max attempts or a permanent error
+	Drop(msg *baetyl.Message, err error, attempts int)
+}
+
+// permanentError the failure of a delivery which won't succeed by retrying
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent wraps the error returned by Deliver, so that the message is dropped at once rather than blocking
+// the following ones of the same key until the max attempts
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Queue a durable queue which keeps messages across restarts and delivers them
+// in order per key with at-least-once semantics
+type Queue struct {
+	cfg     Config
+	db      *bolt.DB
+	handler Handler
+	workers map[string]chan struct{}
+	lock    sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	log     *log.Logger
+}
+
+// New opens the queue and starts to deliver the messages left by the last run
+func New(cfg Config, handler Handler) (*Queue, error) {
+	err := os.MkdirAll(filepath.Dir(cfg.Path), 0755)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	db, err := bolt.Open(cfg.Path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var keys []string
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(bucketDedup); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucketIfNotExists(bucketQueues)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Trace(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		cfg:     cfg,
+		db:      db,
+		handler: handler,
+		workers: map[string]chan struct{}{},
+		ctx:     ctx,
+		cancel:  cancel,
+		log:     log.With(log.Any("function", "queue")),
+	}
+	for _, key := range keys {
+		q.notify(key)
+	}
+	q.wg.Add(1)
+	go q.purging()
+	return q, nil
+}
+
+// Push stores the message at the end of the queue of the key, it returns false
+// if a message with the same key and invokeId was pushed within the dedup window
+func (q *Queue) Push(key, invokeId string, msg *baetyl.Message) (bool, error) {
+	data, err := msg.Marshal()
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+
+	pushed := false
+	err = q.db.Update(func(tx *bolt.Tx) error {
+		dedup := tx.Bucket(bucketDedup)
+		if invokeId != "" {
+			id := []byte(key + "/" + invokeId)
+			if v := dedup.Get(id); v != nil && time.Since(decodeTime(v)) < q.cfg.DedupWindow {
+				return nil
+			}
+			if err := dedup.Put(id, encodeTime(time.Now())); err != nil {
+				return err
+			}
+		}
+		b, err := tx.Bucket(bucketQueues).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		pushed = true
+		return b.Put(encodeSeq(seq), data)
+	})
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	if pushed {
+		q.notify(key)
+	}
+	return pushed, nil
+}
+
+// Len returns the number of the messages waiting in the queue of the key
+func (q *Queue) Len(key string) int {
+	n := 0
+	q.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(bucketQueues).Bucket([]byte(key)); b != nil {
+			n = b.Stats().KeyN
+		}
+		return nil
+	})
+	return n
+}
+
+// Close stops delivering and closes the queue, the undelivered messages are kept
+func (q *Queue) Close() error {
+	q.cancel()
+	q.wg.Wait()
+	return q.db.Close()
+}
+
+// notify wakes up the worker of the key, and starts one if it doesn't exist
+func (q *Queue) notify(key string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	ch, ok := q.workers[key]
+	if !ok {
+		if q.ctx.Err() != nil {
+			return
+		}
+		ch = make(chan struct{}, 1)
+		q.workers[key] = ch
+		q.wg.Add(1)
+		go q.working(key, ch)
+	}
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// working delivers the messages of the key one by one, the head message is removed only after it is
+// delivered or dropped, so the order is kept and nothing is lost if the process exits
+func (q *Queue) working(key string, ch chan struct{}) {
+	defer q.wg.Done()
+
+	attempts := 0
+	backoff := q.cfg.Backoff.Min
+	for {
+		seq, msg, err := q.head(key)
+		if err != nil {
+			q.log.Error("failed to read queue", log.Any("key", key), log.Error(err))
+		}
+		if msg == nil && seq != nil {
+			// the corrupt message can never be delivered, it is removed so that the following ones aren't blocked
+			q.log.Warn("corrupt queued message is dropped", log.Any("key", key))
+			if err := q.remove(key, seq); err == nil {
+				continue
+			}
+			q.log.Error("failed to remove corrupt message from queue", log.Any("key", key), log.Error(err))
+		}
+		if msg == nil {
+			select {
+			case <-ch:
+				continue
+			case <-q.ctx.Done():
+				return
+			}
+		}
+
+		attempts++
+		err = q.handler.Deliver(q.ctx, msg)
+		if q.ctx.Err() != nil {
+			return
+		}
+		permanent := false
+		if perr, ok := err.(*permanentError); ok {
+			err, permanent = perr.err, true
+		}
+		if err != nil && !permanent && (q.cfg.MaxAttempts <= 0 || attempts < q.cfg.MaxAttempts) {
+			q.log.Debug("failed to deliver queued message, will retry", log.Any("key", key), log.Any("attempts", attempts), log.Error(err))
+			select {
+			case <-time.After(backoff):
+			case <-q.ctx.Done():
+				return
+			}
+			backoff *= 2
+			if backoff > q.cfg.Backoff.Max {
+				backoff = q.cfg.Backoff.Max
+			}
+			continue
+		}
+		if err != nil {
+			q.log.Warn("queued message is dropped", log.Any("key", key), log.Any("attempts", attempts), log.Any("permanent", permanent), log.Error(err))
+			q.handler.Drop(msg, err, attempts)
+		}
+		if err := q.remove(key, seq); err != nil {
+			q.log.Error("failed to remove delivered message from queue", log.Any("key", key), log.Error(err))
+		}
+		attempts = 0
+		backoff = q.cfg.Backoff.Min
+	}
+}
+
+// head returns the first message of the key, the message is nil with the seq if it can't be decoded
+func (q *Queue) head(key string) ([]byte, *baetyl.Message, error) {
+	var seq []byte
+	var msg *baetyl.Message
+	err := q.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketQueues).Bucket([]byte(key))
+		if b == nil {
+			return nil
+		}
+		k, v := b.Cursor().First()
+		if k == nil {
+			return nil
+		}
+		seq = append([]byte{}, k...)
+		m := &baetyl.Message{}
+		if err := m.Unmarshal(v); err != nil {
+			return err
+		}
+		msg = m
+		return nil
+	})
+	return seq, msg, errors.Trace(err)
+}
+
+func (q *Queue) remove(key string, seq []byte) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketQueues).Bucket([]byte(key))
+		if b == nil {
+			return nil
+		}
+		return b.Delete(seq)
+	})
+}
+
+// purging removes the expired invokeIds from the dedup bucket periodically
+func (q *Queue) purging() {
+	defer q.wg.Done()
+
+	interval := q.cfg.DedupWindow
+	if interval > time.Minute || interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := q.db.Update(func(tx *bolt.Tx) error {
+				// the keys are collected first since deleting while iterating makes the cursor skip keys
+				b := tx.Bucket(bucketDedup)
+				var expired [][]byte
+				err := b.ForEach(func(k, v []byte) error {
+					if time.Since(decodeTime(v)) >= q.cfg.DedupWindow {
+						expired = append(expired, append([]byte{}, k...))
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				for _, k := range expired {
+					if err := b.Delete(k); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				q.log.Warn("failed to purge expired invokeIds", log.Error(err))
+			}
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
+
+func encodeSeq(seq uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+	return b
+}
+
+func encodeTime(t time.Time) []byte {
+	return encodeSeq(uint64(t.UnixNano()))
+}
+
+func decodeTime(b []byte) time.Time {
+	if len(b) != 8 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+}
@@ -0,0 +1,235 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+type mockHandler struct {
+	lock      sync.Mutex
+	fail      bool
+	delivered map[string][]string
+	dropped   []string
+}
+
+func (h *mockHandler) Deliver(_ context.Context, msg *baetyl.Message) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.fail {
+		return errors.New("unavailable")
+	}
+	if string(msg.Payload) == "bad" {
+		return Permanent(errors.New("bad input"))
+	}
+	key := msg.Metadata["serviceName"]
+	h.delivered[key] = append(h.delivered[key], string(msg.Payload))
+	return nil
+}
+
+func (h *mockHandler) Drop(msg *baetyl.Message, _ error, attempts int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.dropped = append(h.dropped, string(msg.Payload))
+}
+
+func (h *mockHandler) setFail(fail bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.fail = fail
+}
+
+func (h *mockHandler) get(key string) []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]string{}, h.delivered[key]...)
+}
+
+func newConfig(t *testing.T, dir string) Config {
+	var cfg Config
+	err := utils.UnmarshalYAML(nil, &cfg)
+	assert.NoError(t, err)
+	cfg.Enable = true
+	cfg.Path = path.Join(dir, "queue.db")
+	cfg.Backoff.Min = 10 * time.Millisecond
+	cfg.Backoff.Max = 20 * time.Millisecond
+	return cfg
+}
+
+func push(t *testing.T, q *Queue, service, invokeId, payload string) bool {
+	pushed, err := q.Push(service, invokeId, &baetyl.Message{
+		Metadata: map[string]string{"serviceName": service, "invokeId": invokeId},
+		Payload:  []byte(payload),
+	})
+	assert.NoError(t, err)
+	return pushed
+}
+
+func TestQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := newConfig(t, dir)
+	h := &mockHandler{fail: true, delivered: map[string][]string{}}
+	q, err := New(cfg, h)
+	assert.NoError(t, err)
+
+	assert.True(t, push(t, q, "a", "1", "a1"))
+	assert.True(t, push(t, q, "a", "2", "a2"))
+	assert.True(t, push(t, q, "b", "3", "b1"))
+	assert.False(t, push(t, q, "a", "1", "a1"))
+	assert.True(t, push(t, q, "a", "", "a3"))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, q.Len("a"))
+	assert.Equal(t, 1, q.Len("b"))
+
+	// messages are kept across restarts
+	assert.NoError(t, q.Close())
+	h.setFail(false)
+	q, err = New(cfg, h)
+	assert.NoError(t, err)
+	defer q.Close()
+
+	assert.Eventually(t, func() bool {
+		return q.Len("a") == 0 && q.Len("b") == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"a1", "a2", "a3"}, h.get("a"))
+	assert.Equal(t, []string{"b1"}, h.get("b"))
+
+	// dedup still works after restart
+	assert.False(t, push(t, q, "b", "3", "b1"))
+	assert.True(t, push(t, q, "b", "4", "b2"))
+	assert.Eventually(t, func() bool {
+		return len(h.get("b")) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// the same invokeId of another key isn't deduplicated
+	assert.True(t, push(t, q, "a", "3", "a4"))
+	assert.Eventually(t, func() bool {
+		return len(h.get("a")) == 4
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestQueueMaxAttempts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := newConfig(t, dir)
+	cfg.MaxAttempts = 2
+	h := &mockHandler{fail: true, delivered: map[string][]string{}}
+	q, err := New(cfg, h)
+	assert.NoError(t, err)
+	defer q.Close()
+
+	assert.True(t, push(t, q, "a", "1", "a1"))
+	assert.True(t, push(t, q, "a", "2", "a2"))
+	assert.Eventually(t, func() bool {
+		return q.Len("a") == 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	assert.Equal(t, []string{"a1", "a2"}, h.dropped)
+}
+
+func TestQueuePermanent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := newConfig(t, dir)
+	cfg.Backoff.Min = time.Minute
+	cfg.Backoff.Max = time.Minute
+	h := &mockHandler{delivered: map[string][]string{}}
+	q, err := New(cfg, h)
+	assert.NoError(t, err)
+	defer q.Close()
+
+	// the permanent failure is dropped at once and doesn't block the following messages
+	assert.True(t, push(t, q, "a", "1", "bad"))
+	assert.True(t, push(t, q, "a", "2", "a2"))
+	assert.Eventually(t, func() bool {
+		return q.Len("a") == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"a2"}, h.get("a"))
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	assert.Equal(t, []string{"bad"}, h.dropped)
+}
+
+func TestQueueCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := newConfig(t, dir)
+	h := &mockHandler{delivered: map[string][]string{}}
+	q, err := New(cfg, h)
+	assert.NoError(t, err)
+	assert.NoError(t, q.Close())
+
+	db, err := bolt.Open(cfg.Path, 0644, nil)
+	assert.NoError(t, err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(bucketQueues).CreateBucketIfNotExists([]byte("a"))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		return b.Put(encodeSeq(seq), []byte{0xff})
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	// the corrupt message is removed and doesn't block the following messages
+	q, err = New(cfg, h)
+	assert.NoError(t, err)
+	defer q.Close()
+	assert.True(t, push(t, q, "a", "1", "a1"))
+	assert.Eventually(t, func() bool {
+		return q.Len("a") == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"a1"}, h.get("a"))
+}
+
+func TestQueuePurge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := newConfig(t, dir)
+	cfg.DedupWindow = 20 * time.Millisecond
+	h := &mockHandler{delivered: map[string][]string{}}
+	q, err := New(cfg, h)
+	assert.NoError(t, err)
+	defer q.Close()
+
+	for i := 0; i < 100; i++ {
+		assert.True(t, push(t, q, "a", strconv.Itoa(i), "a"))
+	}
+	assert.Eventually(t, func() bool {
+		n := 0
+		q.db.View(func(tx *bolt.Tx) error {
+			n = tx.Bucket(bucketDedup).Stats().KeyN
+			return nil
+		})
+		return n == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,521 @@
+package sql
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+// the metadata of message used by the query
+const (
+	MetadataTopic    = "topic"
+	MetadataQOS      = "qos"
+	MetadataClientID = "clientId"
+	MetadataInvokeID = "invokeId"
+)
+
+// env the input of a query, the payload is a decoded json value
+type env struct {
+	payload  interface{}
+	metadata map[string]string
+	now      time.Time
+}
+
+// Object the result of a query, which is a json object whose fields are in the order of projections
+type Object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newObject() *Object {
+	return &Object{values: map[string]interface{}{}}
+}
+
+// Get returns the value of the field
+func (o *Object) Get(key string) (interface{}, bool) {
+	v, ok := o.values[key]
+	return v, ok
+}
+
+func (o *Object) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// MarshalJSON marshals the fields in order
+func (o *Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		value, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Eval evaluates the query over the decoded json payload with the metadata of message. The message is
+// filtered out if its topic doesn't match the topic filter of FROM clause, or the WHERE condition isn't true,
+// and false is returned. 'SELECT *' alone returns the payload as it is.
+func (q *Query) Eval(payload interface{}, metadata map[string]string) (interface{}, bool, error) {
+	e := &env{payload: payload, metadata: metadata, now: time.Now()}
+	if q.topic != "" {
+		if topic := metadata[MetadataTopic]; topic != "" && !matchTopic(q.topic, topic) {
+			return nil, false, nil
+		}
+	}
+	if q.where != nil {
+		cond, err := q.where.eval(e)
+		if err != nil {
+			return nil, false, errors.Trace(err)
+		}
+		if cond != true {
+			return nil, false, nil
+		}
+	}
+	if len(q.projections) == 1 && q.projections[0].all {
+		return payload, true, nil
+	}
+
+	result := newObject()
+	for _, p := range q.projections {
+		if p.all {
+			if obj, ok := payload.(map[string]interface{}); ok {
+				keys := make([]string, 0, len(obj))
+				for k := range obj {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				for _, k := range keys {
+					result.set(k, obj[k])
+				}
+			}
+			continue
+		}
+		v, err := p.expr.eval(e)
+		if err != nil {
+			return nil, false, errors.Trace(err)
+		}
+		result.set(p.name, v)
+	}
+	return result, true, nil
+}
+
+func (l *literal) eval(_ *env) (interface{}, error) {
+	return l.value, nil
+}
+
+func (f *field) eval(e *env) (interface{}, error) {
+	obj, ok := e.payload.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	return obj[f.name], nil
+}
+
+func (m *member) eval(e *env) (interface{}, error) {
+	target, err := m.target.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := target.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	return obj[m.name], nil
+}
+
+func (i *index) eval(e *env) (interface{}, error) {
+	target, err := i.target.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := i.index.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	switch t := target.(type) {
+	case []interface{}:
+		n, ok := idx.(float64)
+		if !ok || n < 0 || int(n) >= len(t) || n != math.Trunc(n) {
+			return nil, nil
+		}
+		return t[int(n)], nil
+	case map[string]interface{}:
+		k, ok := idx.(string)
+		if !ok {
+			return nil, nil
+		}
+		return t[k], nil
+	}
+	return nil, nil
+}
+
+func (u *unary) eval(e *env) (interface{}, error) {
+	v, err := u.operand.eval(e)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	switch u.op {
+	case "-":
+		n, ok := v.(float64)
+		if !ok {
+			return nil, errors.Errorf("cannot negate %s", typeOf(v))
+		}
+		return -n, nil
+	default:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errors.Errorf("cannot apply NOT to %s", typeOf(v))
+		}
+		return !b, nil
+	}
+}
+
+func (b *binary) eval(e *env) (interface{}, error) {
+	if b.op == "AND" || b.op == "OR" {
+		return b.logical(e)
+	}
+	left, err := b.left.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	right, err := b.right.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	switch b.op {
+	case "||":
+		return toString(left) + toString(right), nil
+	case "+", "-", "*", "/", "%":
+		return arithmetic(b.op, left, right)
+	case "=":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	default:
+		c, ok := compare(left, right)
+		if !ok {
+			return nil, errors.Errorf("cannot compare %s with %s", typeOf(left), typeOf(right))
+		}
+		switch b.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	}
+}
+
+// logical evaluates AND and OR in three-valued logic, the right side is skipped once the result is known
+func (b *binary) logical(e *env) (interface{}, error) {
+	left, err := b.left.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkBool(b.op, left); err != nil {
+		return nil, err
+	}
+	if left == (b.op == "OR") {
+		return left, nil
+	}
+	right, err := b.right.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkBool(b.op, right); err != nil {
+		return nil, err
+	}
+	if right == (b.op == "OR") {
+		return right, nil
+	}
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	// both are true for AND, or both are false for OR
+	return b.op == "AND", nil
+}
+
+func checkBool(op string, v interface{}) error {
+	if _, ok := v.(bool); ok || v == nil {
+		return nil
+	}
+	return errors.Errorf("cannot apply %s to %s", op, typeOf(v))
+}
+
+func arithmetic(op string, left, right interface{}) (interface{}, error) {
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, errors.Errorf("cannot apply '%s' to %s and %s", op, typeOf(left), typeOf(right))
+	}
+	switch op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, nil
+		}
+		return l / r, nil
+	default:
+		if r == 0 {
+			return nil, nil
+		}
+		return math.Mod(l, r), nil
+	}
+}
+
+func (l *like) eval(e *env) (interface{}, error) {
+	target, err := l.target.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := l.pattern.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil || pattern == nil {
+		return nil, nil
+	}
+	s, ok := target.(string)
+	p, pok := pattern.(string)
+	if !ok || !pok {
+		return nil, errors.Errorf("cannot apply LIKE to %s and %s", typeOf(target), typeOf(pattern))
+	}
+	return matchLike(p, s) != l.not, nil
+}
+
+// matchLike matches the string with the pattern in which '%' matches any characters and '_' matches one
+func matchLike(pattern, s string) bool {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String()).MatchString(s)
+}
+
+func (i *in) eval(e *env) (interface{}, error) {
+	target, err := i.target.eval(e)
+	if err != nil || target == nil {
+		return nil, err
+	}
+	for _, item := range i.list {
+		v, err := item.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil && equal(target, v) {
+			return !i.not, nil
+		}
+	}
+	return i.not, nil
+}
+
+func (b *between) eval(e *env) (interface{}, error) {
+	target, err := b.target.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	low, err := b.low.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	high, err := b.high.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil || low == nil || high == nil {
+		return nil, nil
+	}
+	cl, lok := compare(target, low)
+	ch, hok := compare(target, high)
+	if !lok || !hok {
+		return nil, errors.Errorf("cannot apply BETWEEN to %s", typeOf(target))
+	}
+	return (cl >= 0 && ch <= 0) != b.not, nil
+}
+
+func (n *isNull) eval(e *env) (interface{}, error) {
+	v, err := n.target.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	return (v == nil) != n.not, nil
+}
+
+func (c *call) eval(e *env) (interface{}, error) {
+	args := make([]interface{}, len(c.args))
+	for i, a := range c.args {
+		v, err := a.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := c.fn.call(e, args)
+	if err != nil {
+		return nil, errors.Errorf("function %s: %s", c.name, err.Error())
+	}
+	return v, nil
+}
+
+func (c *caseExpr) eval(e *env) (interface{}, error) {
+	var operand interface{}
+	if c.operand != nil {
+		v, err := c.operand.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		operand = v
+	}
+	for _, w := range c.whens {
+		cond, err := w.cond.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		matched := cond == true
+		if c.operand != nil {
+			matched = operand != nil && cond != nil && equal(operand, cond)
+		}
+		if matched {
+			return w.result.eval(e)
+		}
+	}
+	if c.otherwise == nil {
+		return nil, nil
+	}
+	return c.otherwise.eval(e)
+}
+
+func equal(left, right interface{}) bool {
+	if c, ok := compare(left, right); ok {
+		return c == 0
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// compare compares the numbers, the strings or the booleans, false is returned for the other types
+func compare(left, right interface{}) (int, bool) {
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case l < r:
+			return -1, true
+		case l > r:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(l, r), true
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case l == r:
+			return 0, true
+		case !l:
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+// toString converts the value to string, the arrays and the objects are converted to json
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// matchTopic matches the topic with the mqtt topic filter, in which '+' matches one level and '#' matches the rest
+func matchTopic(filter, topic string) bool {
+	fs := strings.Split(filter, "/")
+	ts := strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) || (f != "+" && f != ts[i]) {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
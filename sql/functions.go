@@ -0,0 +1,471 @@
+package sql
+
+import (
+	"math"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+// function the built-in function, it is variadic if maxArgs is negative
+type function struct {
+	minArgs int
+	maxArgs int
+	call    func(e *env, args []interface{}) (interface{}, error)
+}
+
+// the built-in functions, the functions return null if any of the required arguments is null unless stated
+var functions = map[string]*function{
+	// math
+	"abs":   math1(math.Abs),
+	"ceil":  math1(math.Ceil),
+	"floor": math1(math.Floor),
+	"sqrt":  math1(math.Sqrt),
+	"exp":   math1(math.Exp),
+	"ln":    math1(math.Log),
+	"log":   math1(math.Log10),
+	"log2":  math1(math.Log2),
+	"sin":   math1(math.Sin),
+	"cos":   math1(math.Cos),
+	"tan":   math1(math.Tan),
+	"asin":  math1(math.Asin),
+	"acos":  math1(math.Acos),
+	"atan":  math1(math.Atan),
+	"sign": math1(func(x float64) float64 {
+		switch {
+		case x > 0:
+			return 1
+		case x < 0:
+			return -1
+		}
+		return 0
+	}),
+	"round": {1, 2, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		return roundTo(args, math.Round)
+	})},
+	"trunc": {1, 2, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		return roundTo(args, math.Trunc)
+	})},
+	"pow":   math2(math.Pow),
+	"power": math2(math.Pow),
+	"mod":   math2(math.Mod),
+	"atan2": math2(math.Atan2),
+	"pi": {0, 0, func(_ *env, _ []interface{}) (interface{}, error) {
+		return math.Pi, nil
+	}},
+	"rand": {0, 0, func(_ *env, _ []interface{}) (interface{}, error) {
+		return rand.Float64(), nil
+	}},
+
+	// string
+	"concat": {1, -1, func(_ *env, args []interface{}) (interface{}, error) {
+		var b strings.Builder
+		for _, a := range args {
+			b.WriteString(toString(a))
+		}
+		return b.String(), nil
+	}},
+	"lower": string1(strings.ToLower),
+	"upper": string1(strings.ToUpper),
+	"trim":  string1(strings.TrimSpace),
+	"ltrim": string1(func(s string) string { return strings.TrimLeft(s, " \t\r\n") }),
+	"rtrim": string1(func(s string) string { return strings.TrimRight(s, " \t\r\n") }),
+	"length": {1, 1, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v))), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		}
+		return nil, errors.Errorf("cannot get the length of %s", typeOf(args[0]))
+	})},
+	// substring returns the part of string from the start, which is 1-based, and up to the length
+	"substring": {2, 3, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		rs, err := argString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		start, err := argInt(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		s := []rune(rs)
+		from := start - 1
+		if from < 0 {
+			from = 0
+		}
+		if from > len(s) {
+			from = len(s)
+		}
+		to := len(s)
+		if len(args) > 2 {
+			n, err := argInt(args, 2)
+			if err != nil {
+				return nil, err
+			}
+			if start-1+n < to {
+				to = start - 1 + n
+			}
+		}
+		if to < from {
+			return "", nil
+		}
+		return string(s[from:to]), nil
+	})},
+	"replace": {3, 3, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		ss, err := argStrings(args)
+		if err != nil {
+			return nil, err
+		}
+		return strings.Replace(ss[0], ss[1], ss[2], -1), nil
+	})},
+	"startswith": string2(func(s, prefix string) interface{} { return strings.HasPrefix(s, prefix) }),
+	"endswith":   string2(func(s, suffix string) interface{} { return strings.HasSuffix(s, suffix) }),
+	"contains":   string2(func(s, sub string) interface{} { return strings.Contains(s, sub) }),
+	// indexof returns the 0-based position of the substring, -1 if not found
+	"indexof": string2(func(s, sub string) interface{} {
+		i := strings.Index(s, sub)
+		if i < 0 {
+			return float64(-1)
+		}
+		return float64(len([]rune(s[:i])))
+	}),
+	"split": string2(func(s, sep string) interface{} {
+		parts := strings.Split(s, sep)
+		result := make([]interface{}, len(parts))
+		for i, p := range parts {
+			result[i] = p
+		}
+		return result
+	}),
+	"lpad": {2, 3, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		return pad(args, true)
+	})},
+	"rpad": {2, 3, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		return pad(args, false)
+	})},
+	"regexp_matches": {2, 2, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		ss, err := argStrings(args)
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(ss[1])
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return re.MatchString(ss[0]), nil
+	})},
+	"regexp_replace": {3, 3, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		ss, err := argStrings(args)
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(ss[1])
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return re.ReplaceAllString(ss[0], ss[2]), nil
+	})},
+
+	// conversion, null is returned by coalesce only if all arguments are null
+	"coalesce": {1, -1, func(_ *env, args []interface{}) (interface{}, error) {
+		for _, a := range args {
+			if a != nil {
+				return a, nil
+			}
+		}
+		return nil, nil
+	}},
+	"tostring": {1, 1, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		return toString(args[0]), nil
+	})},
+	"tonumber": {1, 1, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case float64:
+			return v, nil
+		case bool:
+			if v {
+				return float64(1), nil
+			}
+			return float64(0), nil
+		case string:
+			n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, nil
+			}
+			return n, nil
+		}
+		return nil, errors.Errorf("cannot convert %s to number", typeOf(args[0]))
+	})},
+	"typeof": {1, 1, func(_ *env, args []interface{}) (interface{}, error) {
+		return typeOf(args[0]), nil
+	}},
+
+	// time, the timestamps are unix milliseconds and the times are in utc unless the zone is given
+	"now": {0, 0, func(e *env, _ []interface{}) (interface{}, error) {
+		return float64(e.now.UnixNano() / int64(time.Millisecond)), nil
+	}},
+	"date_format": {2, 3, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		ms, err := argInt(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		layout, err := argString(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		loc, err := argLocation(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		t := time.Unix(0, int64(ms)*int64(time.Millisecond)).In(loc)
+		return t.Format(goLayout(layout)), nil
+	})},
+	"parse_time": {2, 3, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		s, err := argString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		layout, err := argString(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		loc, err := argLocation(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		t, err := time.ParseInLocation(goLayout(layout), s, loc)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return float64(t.UnixNano() / int64(time.Millisecond)), nil
+	})},
+
+	// message, the topic levels are 1-based
+	"topic": {0, 1, func(e *env, args []interface{}) (interface{}, error) {
+		topic := e.metadata[MetadataTopic]
+		if len(args) == 0 {
+			return metadataValue(topic), nil
+		}
+		if args[0] == nil {
+			return nil, nil
+		}
+		n, err := argInt(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		levels := strings.Split(topic, "/")
+		if topic == "" || n < 1 || n > len(levels) {
+			return nil, nil
+		}
+		return levels[n-1], nil
+	}},
+	// topic_match returns whether the topic of message matches the mqtt topic filter
+	"topic_match": {1, 1, strict(func(e *env, args []interface{}) (interface{}, error) {
+		filter, err := argString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return matchTopic(filter, e.metadata[MetadataTopic]), nil
+	})},
+	"qos": {0, 0, func(e *env, _ []interface{}) (interface{}, error) {
+		qos, err := strconv.ParseFloat(e.metadata[MetadataQOS], 64)
+		if err != nil {
+			return nil, nil
+		}
+		return qos, nil
+	}},
+	"clientid": {0, 0, func(e *env, _ []interface{}) (interface{}, error) {
+		return metadataValue(e.metadata[MetadataClientID]), nil
+	}},
+	"invokeid": {0, 0, func(e *env, _ []interface{}) (interface{}, error) {
+		return metadataValue(e.metadata[MetadataInvokeID]), nil
+	}},
+}
+
+// strict returns null if any of the arguments is null
+func strict(fn func(e *env, args []interface{}) (interface{}, error)) func(e *env, args []interface{}) (interface{}, error) {
+	return func(e *env, args []interface{}) (interface{}, error) {
+		for _, a := range args {
+			if a == nil {
+				return nil, nil
+			}
+		}
+		return fn(e, args)
+	}
+}
+
+func math1(fn func(float64) float64) *function {
+	return &function{1, 1, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		x, err := argNumber(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return checkNumber(fn(x))
+	})}
+}
+
+func math2(fn func(float64, float64) float64) *function {
+	return &function{2, 2, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		x, err := argNumber(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		y, err := argNumber(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return checkNumber(fn(x, y))
+	})}
+}
+
+// checkNumber returns null for NaN and infinity, which json can't represent
+func checkNumber(x float64) (interface{}, error) {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return nil, nil
+	}
+	return x, nil
+}
+
+func string1(fn func(string) string) *function {
+	return &function{1, 1, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		s, err := argString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return fn(s), nil
+	})}
+}
+
+func string2(fn func(string, string) interface{}) *function {
+	return &function{2, 2, strict(func(_ *env, args []interface{}) (interface{}, error) {
+		ss, err := argStrings(args)
+		if err != nil {
+			return nil, err
+		}
+		return fn(ss[0], ss[1]), nil
+	})}
+}
+
+// roundTo rounds the number to the digits after the decimal point, which are 0 if not given
+func roundTo(args []interface{}, fn func(float64) float64) (interface{}, error) {
+	x, err := argNumber(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	digits := 0
+	if len(args) > 1 {
+		if digits, err = argInt(args, 1); err != nil {
+			return nil, err
+		}
+	}
+	scale := math.Pow(10, float64(digits))
+	return checkNumber(fn(x*scale) / scale)
+}
+
+func pad(args []interface{}, left bool) (interface{}, error) {
+	s := toString(args[0])
+	n, err := argInt(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	padding := " "
+	if len(args) > 2 {
+		if padding, err = argString(args, 2); err != nil {
+			return nil, err
+		}
+	}
+	if n > defaultMaxMessageLength {
+		return nil, errors.Errorf("argument 2 is greater than %d", defaultMaxMessageLength)
+	}
+	rs := []rune(s)
+	if len(rs) >= n || padding == "" {
+		return s, nil
+	}
+	ps := []rune(padding)
+	fill := make([]rune, n-len(rs))
+	for i := range fill {
+		fill[i] = ps[i%len(ps)]
+	}
+	if left {
+		return string(fill) + s, nil
+	}
+	return s + string(fill), nil
+}
+
+func argNumber(args []interface{}, i int) (float64, error) {
+	x, ok := args[i].(float64)
+	if !ok {
+		return 0, errors.Errorf("argument %d is %s, not number", i+1, typeOf(args[i]))
+	}
+	return x, nil
+}
+
+func argInt(args []interface{}, i int) (int, error) {
+	x, err := argNumber(args, i)
+	if err != nil {
+		return 0, err
+	}
+	if x != math.Trunc(x) {
+		return 0, errors.Errorf("argument %d is not integer", i+1)
+	}
+	return int(x), nil
+}
+
+func argString(args []interface{}, i int) (string, error) {
+	s, ok := args[i].(string)
+	if !ok {
+		return "", errors.Errorf("argument %d is %s, not string", i+1, typeOf(args[i]))
+	}
+	return s, nil
+}
+
+func argStrings(args []interface{}) ([]string, error) {
+	ss := make([]string, len(args))
+	for i := range args {
+		s, err := argString(args, i)
+		if err != nil {
+			return nil, err
+		}
+		ss[i] = s
+	}
+	return ss, nil
+}
+
+func argLocation(args []interface{}, i int) (*time.Location, error) {
+	if len(args) <= i {
+		return time.UTC, nil
+	}
+	name, err := argString(args, i)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return loc, nil
+}
+
+// the patterns of date_format and parse_time, such as 'yyyy-MM-dd HH:mm:ss.SSS'
+var layoutReplacer = strings.NewReplacer(
+	"yyyy", "2006", "yy", "06", "MM", "01", "dd", "02", "HH", "15", "hh", "03",
+	"mm", "04", "ss", "05", "SSS", "000", "a", "PM", "Z", "Z07:00",
+)
+
+func goLayout(pattern string) string {
+	return layoutReplacer.Replace(pattern)
+}
+
+func metadataValue(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,121 @@
+package sql
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenQuotedIdent
+	tokenNumber
+	tokenString
+	tokenSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// is returns whether the token is the keyword or the symbol, keywords are case insensitive
+func (t token) is(s string) bool {
+	if t.kind == tokenIdent {
+		return strings.EqualFold(t.text, s)
+	}
+	return t.kind == tokenSymbol && t.text == s
+}
+
+// the symbols of two characters are matched before the ones of one character
+var symbols = []string{"<=", ">=", "<>", "!=", "||", "=", "<", ">", "+", "-", "*", "/", "%", "(", ")", ",", ".", "[", "]", ";"}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '-' && i+1 < len(rs) && rs[i+1] == '-':
+			// comment till the end of line
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '\'':
+			text, n, err := scanQuoted(rs, i)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, pos: i})
+			i = n
+		case r == '"' || r == '`':
+			text, n, err := scanQuoted(rs, i)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			tokens = append(tokens, token{kind: tokenQuotedIdent, text: text, pos: i})
+			i = n
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			start := i
+			for i < len(rs) && (unicode.IsDigit(rs[i]) || rs[i] == '.') {
+				i++
+			}
+			if i < len(rs) && (rs[i] == 'e' || rs[i] == 'E') {
+				i++
+				if i < len(rs) && (rs[i] == '+' || rs[i] == '-') {
+					i++
+				}
+				for i < len(rs) && unicode.IsDigit(rs[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(rs[start:i]), pos: start})
+		case unicode.IsLetter(r) || r == '_' || r == '$':
+			start := i
+			for i < len(rs) && (unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]) || rs[i] == '_' || rs[i] == '$') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(rs[start:i]), pos: start})
+		default:
+			matched := false
+			for _, sym := range symbols {
+				if strings.HasPrefix(string(rs[i:]), sym) {
+					tokens = append(tokens, token{kind: tokenSymbol, text: sym, pos: i})
+					i += len([]rune(sym))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, errors.Errorf("unexpected character '%c' at %d", r, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(rs)}), nil
+}
+
+// scanQuoted returns the content between the quotes, the quote inside is escaped by doubling it
+func scanQuoted(rs []rune, start int) (string, int, error) {
+	quote := rs[start]
+	var b strings.Builder
+	for i := start + 1; i < len(rs); i++ {
+		if rs[i] != quote {
+			b.WriteRune(rs[i])
+			continue
+		}
+		if i+1 < len(rs) && rs[i+1] == quote {
+			b.WriteRune(quote)
+			i++
+			continue
+		}
+		return b.String(), i + 1, nil
+	}
+	return "", 0, errors.Errorf("unterminated quote at %d", start)
+}
@@ -0,0 +1,548 @@
+package sql
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+// Query the parsed sql statement, which is 'SELECT projections [FROM 'topic filter'] [WHERE condition]'
+type Query struct {
+	projections []projection
+	topic       string
+	where       expr
+}
+
+type projection struct {
+	all  bool
+	expr expr
+	name string
+}
+
+type expr interface {
+	eval(e *env) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+type field struct {
+	name string
+}
+
+type member struct {
+	target expr
+	name   string
+}
+
+type index struct {
+	target expr
+	index  expr
+}
+
+type unary struct {
+	op      string
+	operand expr
+}
+
+type binary struct {
+	op          string
+	left, right expr
+}
+
+type like struct {
+	target, pattern expr
+	not             bool
+}
+
+type in struct {
+	target expr
+	list   []expr
+	not    bool
+}
+
+type between struct {
+	target, low, high expr
+	not               bool
+}
+
+type isNull struct {
+	target expr
+	not    bool
+}
+
+type call struct {
+	name string
+	fn   *function
+	args []expr
+}
+
+type caseExpr struct {
+	operand   expr
+	whens     []caseWhen
+	otherwise expr
+}
+
+type caseWhen struct {
+	cond, result expr
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses the sql statement, the trailing semicolon and the comments starting with '--' are allowed
+func Parse(s string) (*Query, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	p := &parser{tokens: tokens}
+	q, err := p.query()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return q, nil
+}
+
+// peek returns the current token, which is EOF at the end, so that next can be undone by decrementing pos
+func (p *parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// accept consumes the token if it is the keyword or the symbol
+func (p *parser) accept(s string) bool {
+	if p.peek().is(s) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if !p.accept(s) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return errors.New("unexpected end of statement")
+	}
+	return errors.Errorf("unexpected '%s' at %d", t.text, t.pos)
+}
+
+func (p *parser) query() (*Query, error) {
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	q := &Query{}
+	for {
+		proj, err := p.projection(len(q.projections) + 1)
+		if err != nil {
+			return nil, err
+		}
+		q.projections = append(q.projections, proj)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if p.accept("FROM") {
+		t := p.next()
+		if t.kind != tokenString && t.kind != tokenIdent && t.kind != tokenQuotedIdent {
+			p.pos--
+			return nil, p.unexpected()
+		}
+		q.topic = t.text
+	}
+	if p.accept("WHERE") {
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		q.where = cond
+	}
+	p.accept(";")
+	if p.peek().kind != tokenEOF {
+		return nil, p.unexpected()
+	}
+	return q, nil
+}
+
+// projection returns the projection, its name is the alias, the field name, or '_<position>' otherwise
+func (p *parser) projection(position int) (projection, error) {
+	if p.accept("*") {
+		return projection{all: true}, nil
+	}
+	e, err := p.expr()
+	if err != nil {
+		return projection{}, err
+	}
+	proj := projection{expr: e, name: "_" + strconv.Itoa(position)}
+	switch v := e.(type) {
+	case *field:
+		proj.name = v.name
+	case *member:
+		proj.name = v.name
+	}
+	if p.accept("AS") {
+		t := p.next()
+		if t.kind != tokenIdent && t.kind != tokenQuotedIdent && t.kind != tokenString {
+			p.pos--
+			return projection{}, p.unexpected()
+		}
+		proj.name = t.text
+	} else if t := p.peek(); t.kind == tokenQuotedIdent || (t.kind == tokenIdent && !isKeyword(t.text)) {
+		p.next()
+		proj.name = t.text
+	}
+	return proj, nil
+}
+
+var keywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "AS": true, "AND": true, "OR": true, "NOT": true,
+	"LIKE": true, "IN": true, "BETWEEN": true, "IS": true, "NULL": true, "TRUE": true, "FALSE": true,
+	"CASE": true, "WHEN": true, "THEN": true, "ELSE": true, "END": true,
+}
+
+func isKeyword(s string) bool {
+	return keywords[strings.ToUpper(s)]
+}
+
+func (p *parser) expr() (expr, error) {
+	return p.or()
+}
+
+func (p *parser) or() (expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (expr, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (expr, error) {
+	if p.accept("NOT") {
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "NOT", operand: operand}, nil
+	}
+	return p.predicate()
+}
+
+func (p *parser) predicate() (expr, error) {
+	left, err := p.concat()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"=", "!=", "<>", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.concat()
+			if err != nil {
+				return nil, err
+			}
+			if op == "<>" {
+				op = "!="
+			}
+			return &binary{op: op, left: left, right: right}, nil
+		}
+	}
+	if p.accept("IS") {
+		not := p.accept("NOT")
+		if err = p.expect("NULL"); err != nil {
+			return nil, err
+		}
+		return &isNull{target: left, not: not}, nil
+	}
+
+	not := p.accept("NOT")
+	switch {
+	case p.accept("LIKE"):
+		pattern, err := p.concat()
+		if err != nil {
+			return nil, err
+		}
+		return &like{target: left, pattern: pattern, not: not}, nil
+	case p.accept("IN"):
+		if err = p.expect("("); err != nil {
+			return nil, err
+		}
+		list, err := p.list(")")
+		if err != nil {
+			return nil, err
+		}
+		return &in{target: left, list: list, not: not}, nil
+	case p.accept("BETWEEN"):
+		low, err := p.concat()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.concat()
+		if err != nil {
+			return nil, err
+		}
+		return &between{target: left, low: low, high: high, not: not}, nil
+	}
+	if not {
+		return nil, p.unexpected()
+	}
+	return left, nil
+}
+
+// list parses the comma-separated expressions till the closing symbol
+func (p *parser) list(closing string) ([]expr, error) {
+	var list []expr
+	if p.accept(closing) {
+		return list, nil
+	}
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if p.accept(closing) {
+			return list, nil
+		}
+		if err = p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) concat() (expr, error) {
+	left, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) additive() (expr, error) {
+	left, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek().text
+		if !p.accept("+") && !p.accept("-") {
+			return left, nil
+		}
+		right, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) multiplicative() (expr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek().text
+		if !p.accept("*") && !p.accept("/") && !p.accept("%") {
+			return left, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.accept("-") {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "-", operand: operand}, nil
+	}
+	if p.accept("+") {
+		return p.unary()
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (expr, error) {
+	e, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokenIdent && t.kind != tokenQuotedIdent {
+				p.pos--
+				return nil, p.unexpected()
+			}
+			e = &member{target: e, name: t.text}
+		case p.accept("["):
+			i, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+			e = &index{target: e, index: i}
+		default:
+			return e, nil
+		}
+	}
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid number '%s' at %d", t.text, t.pos)
+		}
+		return &literal{value: v}, nil
+	case tokenString:
+		return &literal{value: t.text}, nil
+	case tokenQuotedIdent:
+		return &field{name: t.text}, nil
+	case tokenSymbol:
+		if t.text == "(" {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(")"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		}
+	case tokenIdent:
+		switch strings.ToUpper(t.text) {
+		case "NULL":
+			return &literal{value: nil}, nil
+		case "TRUE":
+			return &literal{value: true}, nil
+		case "FALSE":
+			return &literal{value: false}, nil
+		case "CASE":
+			return p.caseExpr()
+		}
+		if isKeyword(t.text) {
+			break
+		}
+		if p.accept("(") {
+			return p.call(t)
+		}
+		return &field{name: t.text}, nil
+	}
+	p.pos--
+	return nil, p.unexpected()
+}
+
+func (p *parser) call(name token) (expr, error) {
+	fn, ok := functions[strings.ToLower(name.text)]
+	if !ok {
+		return nil, errors.Errorf("unknown function '%s' at %d", name.text, name.pos)
+	}
+	args, err := p.list(")")
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, errors.Errorf("wrong number of arguments of function '%s' at %d", name.text, name.pos)
+	}
+	return &call{name: strings.ToLower(name.text), fn: fn, args: args}, nil
+}
+
+// caseExpr parses both 'CASE WHEN cond THEN result ... END' and 'CASE operand WHEN value THEN result ... END'
+func (p *parser) caseExpr() (expr, error) {
+	c := &caseExpr{}
+	if !p.peek().is("WHEN") {
+		operand, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		c.operand = operand
+	}
+	for p.accept("WHEN") {
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect("THEN"); err != nil {
+			return nil, err
+		}
+		result, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		c.whens = append(c.whens, caseWhen{cond: cond, result: result})
+	}
+	if len(c.whens) == 0 {
+		return nil, p.unexpected()
+	}
+	if p.accept("ELSE") {
+		otherwise, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		c.otherwise = otherwise
+	}
+	if err := p.expect("END"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,210 @@
+package sql
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/baetyl/baetyl-function/v2/errdetail"
+)
+
+const defaultMaxMessageLength = 4 * 1024 * 1024
+
+// Config the config of sql runtime
+type Config struct {
+	Server    ServerConfig     `yaml:"server" json:"server"`
+	Functions []FunctionConfig `yaml:"functions" json:"functions"`
+}
+
+// ServerConfig the grpc server, the system certificate is used if the certificate isn't specified
+type ServerConfig struct {
+	Address           string        `yaml:"address" json:"address"`
+	Concurrent        LimitConfig   `yaml:"concurrent" json:"concurrent"`
+	Message           MessageConfig `yaml:"message" json:"message"`
+	utils.Certificate `yaml:",inline" json:",inline"`
+}
+
+// MessageConfig the limit of message length, which is 4MB if not specified
+type MessageConfig struct {
+	Length LimitConfig `yaml:"length" json:"length"`
+}
+
+// LimitConfig the limit which is unlimited if zero
+type LimitConfig struct {
+	Max int `yaml:"max" json:"max"`
+}
+
+// FunctionConfig the function whose handler is the sql script in the code dir, the suffix '.sql' can be omitted
+type FunctionConfig struct {
+	Name    string `yaml:"name" json:"name" validate:"nonzero"`
+	Handler string `yaml:"handler" json:"handler" validate:"nonzero"`
+	CodeDir string `yaml:"codedir" json:"codedir"`
+}
+
+// Runtime the sql runtime, which implements the baetyl.Function grpc server
+type Runtime struct {
+	cfg       Config
+	functions map[string]*Query
+	svr       *grpc.Server
+	log       *log.Logger
+}
+
+// NewRuntime loads the sql scripts of functions from the code path
+func NewRuntime(cfg Config, codePath string) (*Runtime, error) {
+	r := &Runtime{
+		cfg:       cfg,
+		functions: map[string]*Query{},
+		log:       log.With(log.Any("runtime", "sql")),
+	}
+	for _, fc := range cfg.Functions {
+		if fc.Name == "" || fc.Handler == "" {
+			return nil, errors.New("config invalid, missing function name or handler")
+		}
+		q, err := loadScript(filepath.Join(codePath, fc.CodeDir, fc.Handler))
+		if err != nil {
+			return nil, errors.Errorf("failed to load function (%s): %s", fc.Name, err.Error())
+		}
+		r.functions[fc.Name] = q
+	}
+	return r, nil
+}
+
+func loadScript(file string) (*Query, error) {
+	if _, err := os.Stat(file); os.IsNotExist(err) && filepath.Ext(file) == "" {
+		file += ".sql"
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	q, err := Parse(string(data))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return q, nil
+}
+
+// Start listens on the address and serves
+func (r *Runtime) Start() error {
+	cfg := r.cfg.Server
+	maxLength := cfg.Message.Length.Max
+	if maxLength <= 0 {
+		maxLength = defaultMaxMessageLength
+	}
+	opts := []grpc.ServerOption{grpc.MaxRecvMsgSize(maxLength), grpc.MaxSendMsgSize(maxLength)}
+	if cfg.Concurrent.Max > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(uint32(cfg.Concurrent.Max)))
+	}
+	if cfg.Key != "" && cfg.Cert != "" {
+		cert := cfg.Certificate
+		if cert.CA != "" {
+			cert.ClientAuthType = tls.RequireAndVerifyClientCert
+		}
+		tlsConfig, err := utils.NewTLSConfigServer(cert)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	ln, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	r.svr = grpc.NewServer(opts...)
+	baetyl.RegisterFunctionServer(r.svr, r)
+	go func() {
+		r.log.Info("service starting", log.Any("address", cfg.Address))
+		if err := r.svr.Serve(ln); err != nil {
+			r.log.Error("service shutdown", log.Error(err))
+		}
+	}()
+	return nil
+}
+
+// Close stops the server gracefully
+func (r *Runtime) Close() {
+	if r.svr != nil {
+		r.svr.GracefulStop()
+	}
+	r.log.Info("service closed")
+}
+
+// Call evaluates the sql of the function over the json payload. The payload is empty if the message is filtered
+// out. In batch mode, the payload is a json array of events and a json array of results is returned, in which
+// the results of the events filtered out are null.
+func (r *Runtime) Call(_ context.Context, msg *baetyl.Message) (*baetyl.Message, error) {
+	name := msg.Metadata["functionName"]
+	if name == "" && len(r.cfg.Functions) > 0 {
+		name = r.cfg.Functions[0].Name
+	}
+	q, ok := r.functions[name]
+	if !ok {
+		r.log.Error("the function doesn't found", log.Any("function", name))
+		return nil, errdetail.New(codes.NotFound, errdetail.ReasonFunctionNotFound, "the function doesn't found: "+name, false)
+	}
+
+	var payload interface{}
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return nil, errdetail.New(codes.InvalidArgument, errdetail.ReasonBadInput, "the payload is not json: "+err.Error(), false)
+		}
+	}
+
+	var result interface{}
+	if msg.Metadata["batch"] == "true" {
+		events, ok := payload.([]interface{})
+		if !ok {
+			return nil, errdetail.New(codes.InvalidArgument, errdetail.ReasonBadInput, "the batch payload is not a json array", false)
+		}
+		results := make([]interface{}, len(events))
+		for i, event := range events {
+			// non-json payloads are put into batch as strings
+			if s, ok := event.(string); ok {
+				_ = json.Unmarshal([]byte(s), &event)
+			}
+			v, selected, err := q.Eval(event, msg.Metadata)
+			if err != nil {
+				return nil, r.userCodeError(name, err)
+			}
+			if selected {
+				results[i] = v
+			}
+		}
+		result = results
+	} else {
+		v, selected, err := q.Eval(payload, msg.Metadata)
+		if err != nil {
+			return nil, r.userCodeError(name, err)
+		}
+		if !selected {
+			msg.Payload = []byte{}
+			return msg, nil
+		}
+		result = v
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		return nil, r.userCodeError(name, err)
+	}
+	msg.Payload = b
+	return msg, nil
+}
+
+func (r *Runtime) userCodeError(name string, err error) error {
+	r.log.Error("error when invoking function", log.Any("function", name), log.Error(err))
+	return errdetail.New(codes.Unknown, errdetail.ReasonUserCode, "[UserCodeInvoke] "+err.Error(), false)
+}
@@ -0,0 +1,102 @@
+package sql
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/baetyl/baetyl-function/v2/errdetail"
+)
+
+func TestRuntime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sql")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "rules"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "rules", "filter.sql"), []byte("SELECT id, temp * 2 AS double WHERE temp > 20"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "rules", "all.sql"), []byte("-- passthrough\nSELECT *;"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "broken.sql"), []byte("SELECT unknown(1)"), 0644))
+
+	cfg := Config{
+		Server: ServerConfig{Address: "127.0.0.1:50081"},
+		Functions: []FunctionConfig{
+			{Name: "filter", Handler: "filter", CodeDir: "rules"},
+			{Name: "all", Handler: "all.sql", CodeDir: "rules"},
+		},
+	}
+	_, err = NewRuntime(Config{Functions: []FunctionConfig{{Name: "broken", Handler: "broken"}}}, dir)
+	assert.EqualError(t, err, "failed to load function (broken): unknown function 'unknown' at 7")
+	_, err = NewRuntime(Config{Functions: []FunctionConfig{{Name: "missing", Handler: "missing"}}}, dir)
+	assert.Error(t, err)
+
+	r, err := NewRuntime(cfg, dir)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Start())
+	defer r.Close()
+
+	conn, err := grpc.Dial(cfg.Server.Address, grpc.WithInsecure(), grpc.WithBlock())
+	assert.NoError(t, err)
+	defer conn.Close()
+	cli := baetyl.NewFunctionClient(conn)
+
+	call := func(function, batch, payload string) (*baetyl.Message, error) {
+		return cli.Call(context.Background(), &baetyl.Message{
+			Metadata: map[string]string{"functionName": function, "batch": batch},
+			Payload:  []byte(payload),
+		})
+	}
+	reasonOf := func(err error) string {
+		st, ok := status.FromError(err)
+		assert.True(t, ok)
+		assert.Len(t, st.Details(), 1)
+		return st.Details()[0].(*structpb.Struct).Fields["reason"].GetStringValue()
+	}
+
+	res, err := call("filter", "", `{"id":1,"temp":25}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"double":50}`, string(res.Payload))
+	assert.Equal(t, "filter", res.Metadata["functionName"])
+
+	res, err = call("filter", "", `{"id":1,"temp":15}`)
+	assert.NoError(t, err)
+	assert.Empty(t, res.Payload)
+
+	// the first function is used if the function name is empty
+	res, err = call("", "", `{"id":2,"temp":30}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":2,"double":60}`, string(res.Payload))
+
+	res, err = call("all", "", `[1,"a"]`)
+	assert.NoError(t, err)
+	assert.Equal(t, `[1,"a"]`, string(res.Payload))
+
+	res, err = call("filter", "true", `[{"id":1,"temp":25},{"id":2,"temp":15},"{\"id\":3,\"temp\":21}"]`)
+	assert.NoError(t, err)
+	assert.Equal(t, `[{"id":1,"double":50},null,{"id":3,"double":42}]`, string(res.Payload))
+
+	_, err = call("missing", "", `{}`)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, errdetail.ReasonFunctionNotFound, reasonOf(err))
+
+	_, err = call("filter", "", `not json`)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, errdetail.ReasonBadInput, reasonOf(err))
+
+	_, err = call("filter", "true", `{}`)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, errdetail.ReasonBadInput, reasonOf(err))
+
+	_, err = call("filter", "", `{"id":1,"temp":"hot"}`)
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.Equal(t, errdetail.ReasonUserCode, reasonOf(err))
+	assert.Contains(t, status.Convert(err).Message(), "cannot compare string with number")
+}
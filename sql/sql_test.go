@@ -0,0 +1,185 @@
+package sql
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuery(t *testing.T) {
+	metadata := map[string]string{
+		MetadataTopic:    "factory/line1/temperature",
+		MetadataQOS:      "1",
+		MetadataClientID: "sensor-1",
+		MetadataInvokeID: "abc",
+	}
+	payload := `{"id":1,"name":"Sensor A","temp":26.5,"tags":["a","b"],"loc":{"city":"Beijing","floor":3},"status":null,"on":true,"ts":1600000000000}`
+
+	cases := []struct {
+		query   string
+		payload string
+		result  string // empty if the message is filtered out
+		err     string
+	}{
+		// projections
+		{query: "SELECT *", payload: `{"a":1}`, result: `{"a":1}`},
+		{query: "select *", payload: `[1,2]`, result: `[1,2]`},
+		{query: "SELECT id, name", payload: payload, result: `{"id":1,"name":"Sensor A"}`},
+		{query: "SELECT id AS sensorId, temp t", payload: payload, result: `{"sensorId":1,"t":26.5}`},
+		{query: "SELECT loc.city, tags[1], tags[5]", payload: payload, result: `{"city":"Beijing","_2":"b","_3":null}`},
+		{query: "SELECT loc['floor'] AS floor, \"name\"", payload: payload, result: `{"floor":3,"name":"Sensor A"}`},
+		{query: "SELECT *, temp * 2 AS double", payload: `{"temp":2,"b":1}`, result: `{"b":1,"temp":2,"double":4}`},
+		{query: "SELECT missing, missing.field", payload: payload, result: `{"missing":null,"field":null}`},
+		{query: "SELECT 'it''s', 1.5e2, TRUE, NULL", payload: payload, result: `{"_1":"it's","_2":150,"_3":true,"_4":null}`},
+		{query: "SELECT id; -- the comment", payload: payload, result: `{"id":1}`},
+
+		// arithmetic and operators
+		{query: "SELECT (temp - 20) * 2 + 1 AS v, -id AS n, 7 % 4 AS m", payload: payload, result: `{"v":14,"n":-1,"m":3}`},
+		{query: "SELECT 1 / 0 AS v, temp + status AS w", payload: payload, result: `{"v":null,"w":null}`},
+		{query: "SELECT name || '-' || id AS v", payload: payload, result: `{"v":"Sensor A-1"}`},
+		{query: "SELECT name + 1", payload: payload, err: "cannot apply '+' to string and number"},
+
+		// where
+		{query: "SELECT id WHERE temp > 25", payload: payload, result: `{"id":1}`},
+		{query: "SELECT id WHERE temp > 30", payload: payload},
+		{query: "SELECT id WHERE temp >= 26.5 AND name = 'Sensor A'", payload: payload, result: `{"id":1}`},
+		{query: "SELECT id WHERE temp < 0 OR on", payload: payload, result: `{"id":1}`},
+		{query: "SELECT id WHERE NOT on", payload: payload},
+		{query: "SELECT id WHERE id <> 1", payload: payload},
+		{query: "SELECT id WHERE status = 1", payload: payload},
+		{query: "SELECT id WHERE status = 1 OR id = 1", payload: payload, result: `{"id":1}`},
+		{query: "SELECT id WHERE status IS NULL AND loc IS NOT NULL", payload: payload, result: `{"id":1}`},
+		{query: "SELECT id WHERE name LIKE 'Sensor%'", payload: payload, result: `{"id":1}`},
+		{query: "SELECT id WHERE name LIKE 'Sensor _'", payload: payload, result: `{"id":1}`},
+		{query: "SELECT id WHERE name NOT LIKE '%A'", payload: payload},
+		{query: "SELECT id WHERE loc.city IN ('Shanghai', 'Beijing')", payload: payload, result: `{"id":1}`},
+		{query: "SELECT id WHERE id NOT IN (1, 2)", payload: payload},
+		{query: "SELECT id WHERE temp BETWEEN 20 AND 30", payload: payload, result: `{"id":1}`},
+		{query: "SELECT id WHERE temp NOT BETWEEN 20 AND 30", payload: payload},
+		{query: "SELECT id WHERE tags = tags", payload: payload, result: `{"id":1}`},
+		{query: "SELECT id WHERE name > 1", payload: payload, err: "cannot compare string with number"},
+		{query: "SELECT id WHERE temp AND on", payload: payload, err: "cannot apply AND to number"},
+
+		// case
+		{query: "SELECT CASE WHEN temp > 30 THEN 'hot' WHEN temp > 20 THEN 'warm' ELSE 'cold' END AS level", payload: payload, result: `{"level":"warm"}`},
+		{query: "SELECT CASE loc.floor WHEN 1 THEN 'ground' WHEN 3 THEN 'top' END AS floor", payload: payload, result: `{"floor":"top"}`},
+		{query: "SELECT CASE WHEN temp > 30 THEN 'hot' END AS level", payload: payload, result: `{"level":null}`},
+
+		// math functions
+		{query: "SELECT abs(-2), ceil(1.2), floor(1.8), sqrt(16), round(2.567, 2), trunc(-2.5), pow(2, 10), mod(7, 3)", payload: payload,
+			result: `{"_1":2,"_2":2,"_3":1,"_4":4,"_5":2.57,"_6":-2,"_7":1024,"_8":1}`},
+		{query: "SELECT sign(-3), log(100), log2(8), ln(1), sqrt(-1), round(status)", payload: payload,
+			result: `{"_1":-1,"_2":2,"_3":3,"_4":0,"_5":null,"_6":null}`},
+		{query: "SELECT abs(name)", payload: payload, err: "function abs: argument 1 is string, not number"},
+
+		// string functions
+		{query: "SELECT lower(name), upper(name), length(name), length(tags), trim('  x ')", payload: payload,
+			result: `{"_1":"sensor a","_2":"SENSOR A","_3":8,"_4":2,"_5":"x"}`},
+		{query: "SELECT substring(name, 1, 6), substring(name, 8), replace(name, 'Sensor', 'S')", payload: payload,
+			result: `{"_1":"Sensor","_2":"A","_3":"S A"}`},
+		{query: "SELECT startswith(name, 'Sen'), endswith(name, 'B'), contains(name, 'or'), indexof(name, 'A'), indexof(name, 'x')", payload: payload,
+			result: `{"_1":true,"_2":false,"_3":true,"_4":7,"_5":-1}`},
+		{query: "SELECT split('a,b,c', ','), concat(name, '#', id), lpad(id, 3, '0'), rpad('x', 3)", payload: payload,
+			result: `{"_1":["a","b","c"],"_2":"Sensor A#1","_3":"001","_4":"x  "}`},
+		{query: "SELECT rpad(name, 1000000000000, 'x')", payload: payload, err: "function rpad: argument 2 is greater than 4194304"},
+		{query: "SELECT lpad(id, 4, 'ab'), rpad('x', 4, '日本')", payload: payload, result: `{"_1":"aba1","_2":"x日本日"}`},
+		{query: "SELECT regexp_matches(name, '^S.+A$'), regexp_replace(name, '[aeiou]', '*')", payload: payload,
+			result: `{"_1":true,"_2":"S*ns*r A"}`},
+		{query: "SELECT regexp_matches(name, '(')", payload: payload, err: "error parsing regexp"},
+
+		// conversion functions
+		{query: "SELECT coalesce(status, missing, 'default'), tostring(loc), tonumber('12.5'), tonumber('x')", payload: payload,
+			result: `{"_1":"default","_2":"{\"city\":\"Beijing\",\"floor\":3}","_3":12.5,"_4":null}`},
+		{query: "SELECT typeof(id), typeof(name), typeof(on), typeof(tags), typeof(loc), typeof(status)", payload: payload,
+			result: `{"_1":"number","_2":"string","_3":"boolean","_4":"array","_5":"object","_6":"null"}`},
+
+		// time functions
+		{query: "SELECT date_format(ts, 'yyyy-MM-dd HH:mm:ss.SSS', 'UTC') AS t", payload: payload, result: `{"t":"2020-09-13 12:26:40.000"}`},
+		{query: "SELECT parse_time('2020-09-13 20:26:40', 'yyyy-MM-dd HH:mm:ss', 'Asia/Shanghai') AS t", payload: payload, result: `{"t":1600000000000}`},
+		{query: "SELECT date_format(ts, 'yyyy', 'Nowhere/City')", payload: payload, err: "unknown time zone"},
+		{query: "SELECT id WHERE now() > ts", payload: payload, result: `{"id":1}`},
+
+		// message functions
+		{query: "SELECT topic() AS t, topic(2) AS line, topic(9) AS x, qos() AS q, clientid() AS c, invokeid() AS i", payload: payload,
+			result: `{"t":"factory/line1/temperature","line":"line1","x":null,"q":1,"c":"sensor-1","i":"abc"}`},
+		{query: "SELECT id WHERE topic_match('factory/+/temperature')", payload: payload, result: `{"id":1}`},
+		{query: "SELECT id WHERE topic_match('factory/line2/#')", payload: payload},
+
+		// topic filter
+		{query: "SELECT id FROM 'factory/#'", payload: payload, result: `{"id":1}`},
+		{query: "SELECT id FROM 'factory/+/humidity'", payload: payload},
+		{query: "SELECT id FROM 'factory/+/temperature' WHERE temp > 20", payload: payload, result: `{"id":1}`},
+
+		// non-object payloads
+		{query: "SELECT id", payload: `"text"`, result: `{"id":null}`},
+		{query: "SELECT id", payload: ``, result: `{"id":null}`},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			q, err := Parse(c.query)
+			assert.NoError(t, err)
+
+			var p interface{}
+			if c.payload != "" {
+				assert.NoError(t, json.Unmarshal([]byte(c.payload), &p))
+			}
+			res, ok, err := q.Eval(p, metadata)
+			if c.err != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), c.err)
+				return
+			}
+			assert.NoError(t, err)
+			if c.result == "" {
+				assert.False(t, ok)
+				return
+			}
+			assert.True(t, ok)
+			b, err := json.Marshal(res)
+			assert.NoError(t, err)
+			assert.Equal(t, c.result, string(b))
+		})
+	}
+}
+
+func TestQueryWithoutTopic(t *testing.T) {
+	q, err := Parse("SELECT id, topic() AS t, qos() AS q FROM 'factory/#'")
+	assert.NoError(t, err)
+	res, ok, err := q.Eval(map[string]interface{}{"id": 1.0}, nil)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	b, err := json.Marshal(res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"t":null,"q":null}`, string(b))
+}
+
+func TestParseError(t *testing.T) {
+	cases := map[string]string{
+		"":                                 "unexpected end of statement",
+		"id":                               "unexpected 'id' at 0",
+		"SELECT":                           "unexpected end of statement",
+		"SELECT id,":                       "unexpected end of statement",
+		"SELECT id FROM":                   "unexpected end of statement",
+		"SELECT id WHERE":                  "unexpected end of statement",
+		"SELECT id id2 id3":                "unexpected 'id3' at 14",
+		"SELECT (id":                       "unexpected end of statement",
+		"SELECT 'id":                       "unterminated quote at 7",
+		"SELECT id # 1":                    "unexpected character '#' at 10",
+		"SELECT unknown(1)":                "unknown function 'unknown' at 7",
+		"SELECT abs(1, 2)":                 "wrong number of arguments of function 'abs' at 7",
+		"SELECT now(1)":                    "wrong number of arguments of function 'now' at 7",
+		"SELECT id WHERE a IS 1":           "unexpected '1' at 21",
+		"SELECT id WHERE a NOT 1":          "unexpected '1' at 22",
+		"SELECT id WHERE a BETWEEN 1 OR 2": "unexpected 'OR' at 28",
+		"SELECT CASE END":                  "unexpected 'END' at 12",
+		"SELECT CASE WHEN a THEN 1":        "unexpected end of statement",
+		"SELECT a.1":                       "unexpected '.1' at 8",
+	}
+	for s, msg := range cases {
+		_, err := Parse(s)
+		if assert.Error(t, err, s) {
+			assert.Equal(t, msg, err.Error(), s)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package wasm
+
+import (
+	"context"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// HostModule the name of the module whose functions are imported by the wasm modules to access the message,
+// the pointers and the lengths are offsets and sizes in bytes of the memory exported by the wasm module:
+//
+//	payload_size() -> i32                          returns the length of payload
+//	payload_read(ptr, len i32) -> i32              copies the payload to memory, returns the length copied
+//	output_write(ptr, len i32)                     appends the data to the output payload, the call traps if the
+//	                                               output exceeds the max message length
+//	metadata_get(kptr, klen, vptr, vlen i32) -> i32 copies the value of metadata to memory, returns the length of
+//	                                               value, or -1 if the key doesn't exist
+//	metadata_set(kptr, klen, vptr, vlen i32)       sets the metadata of the output message
+//	error_write(ptr, len i32)                      sets the error message, which is reported if the handler fails
+//	log(ptr, len i32)                              writes the message to the log of runtime
+//
+// The handler is exported by the wasm module without parameters and returns i32, which is 0 if it succeeds.
+const HostModule = "baetyl"
+
+type invocationKey struct{}
+
+// invocation the state of a call shared by the host functions
+type invocation struct {
+	function  string
+	payload   []byte
+	metadata  map[string]string
+	output    []byte
+	maxOutput int
+	err       string
+	log       *log.Logger
+}
+
+func withInvocation(ctx context.Context, inv *invocation) context.Context {
+	return context.WithValue(ctx, invocationKey{}, inv)
+}
+
+// invocationOf returns the invocation of the call, an empty one is returned when instantiating the module
+func invocationOf(ctx context.Context) *invocation {
+	if inv, ok := ctx.Value(invocationKey{}).(*invocation); ok {
+		return inv
+	}
+	return &invocation{metadata: map[string]string{}, maxOutput: defaultMaxMessageLength, log: log.With(log.Any("runtime", "wasm"))}
+}
+
+// read returns the copy of memory, the call traps if it is out of range
+func read(m api.Module, ptr, size uint32) []byte {
+	buf, ok := m.Memory().Read(ptr, size)
+	if !ok {
+		panic(errors.Errorf("memory access out of range (ptr=%d, len=%d)", ptr, size))
+	}
+	return append([]byte{}, buf...)
+}
+
+func write(m api.Module, ptr uint32, data []byte) {
+	if !m.Memory().Write(ptr, data) {
+		panic(errors.Errorf("memory access out of range (ptr=%d, len=%d)", ptr, len(data)))
+	}
+}
+
+func instantiateHostModule(ctx context.Context, r wazero.Runtime) error {
+	_, err := r.NewHostModuleBuilder(HostModule).
+		NewFunctionBuilder().WithFunc(payloadSize).Export("payload_size").
+		NewFunctionBuilder().WithFunc(payloadRead).Export("payload_read").
+		NewFunctionBuilder().WithFunc(outputWrite).Export("output_write").
+		NewFunctionBuilder().WithFunc(metadataGet).Export("metadata_get").
+		NewFunctionBuilder().WithFunc(metadataSet).Export("metadata_set").
+		NewFunctionBuilder().WithFunc(errorWrite).Export("error_write").
+		NewFunctionBuilder().WithFunc(logWrite).Export("log").
+		Instantiate(ctx)
+	return errors.Trace(err)
+}
+
+func payloadSize(ctx context.Context) int32 {
+	return int32(len(invocationOf(ctx).payload))
+}
+
+func payloadRead(ctx context.Context, m api.Module, ptr, size uint32) int32 {
+	payload := invocationOf(ctx).payload
+	if uint32(len(payload)) < size {
+		size = uint32(len(payload))
+	}
+	write(m, ptr, payload[:size])
+	return int32(size)
+}
+
+func outputWrite(ctx context.Context, m api.Module, ptr, size uint32) {
+	inv := invocationOf(ctx)
+	if len(inv.output)+int(size) > inv.maxOutput {
+		panic(errors.Errorf("the output exceeds the max message length (%d)", inv.maxOutput))
+	}
+	inv.output = append(inv.output, read(m, ptr, size)...)
+}
+
+func metadataGet(ctx context.Context, m api.Module, kptr, klen, vptr, vlen uint32) int32 {
+	v, ok := invocationOf(ctx).metadata[string(read(m, kptr, klen))]
+	if !ok {
+		return -1
+	}
+	if uint32(len(v)) < vlen {
+		vlen = uint32(len(v))
+	}
+	write(m, vptr, []byte(v[:vlen]))
+	return int32(len(v))
+}
+
+func metadataSet(ctx context.Context, m api.Module, kptr, klen, vptr, vlen uint32) {
+	invocationOf(ctx).metadata[string(read(m, kptr, klen))] = string(read(m, vptr, vlen))
+}
+
+func errorWrite(ctx context.Context, m api.Module, ptr, size uint32) {
+	invocationOf(ctx).err = string(read(m, ptr, size))
+}
+
+func logWrite(ctx context.Context, m api.Module, ptr, size uint32) {
+	inv := invocationOf(ctx)
+	inv.log.Info(string(read(m, ptr, size)), log.Any("function", inv.function))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+
+	"github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+
+	"github.com/baetyl/baetyl-function/v2/wasm"
+)
+
+const (
+	keyCodePath     = "BAETYL_CODE_PATH"
+	defaultCodePath = "var/lib/baetyl/code"
+)
+
+func main() {
+	context.Run(func(ctx context.Context) error {
+		if err := ctx.CheckSystemCert(); err != nil {
+			return err
+		}
+
+		var cfg wasm.Config
+		err := ctx.LoadCustomConfig(&cfg)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if cfg.Server.Address == "" {
+			cfg.Server.Address = "0.0.0.0:80"
+			if context.RunMode() == context.RunModeNative {
+				cfg.Server.Address = "127.0.0.1:" + os.Getenv(context.KeyServiceDynamicPort)
+			}
+		}
+		if cfg.Server.Cert == "" && cfg.Server.Key == "" {
+			cfg.Server.Certificate = ctx.SystemConfig().Certificate
+		}
+
+		codePath := os.Getenv(keyCodePath)
+		if codePath == "" {
+			codePath = defaultCodePath
+		}
+		r, err := wasm.NewRuntime(cfg, codePath)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = r.Start(); err != nil {
+			return errors.Trace(err)
+		}
+		defer r.Close()
+		ctx.Wait()
+		return nil
+	})
+}
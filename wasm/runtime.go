@@ -0,0 +1,318 @@
+package wasm
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-function/v2/errdetail"
+	"github.com/baetyl/baetyl-go/v2/errors"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultMaxMessageLength = 4 * 1024 * 1024
+	defaultTimeout          = 30 * time.Second
+	defaultMemory           = 16 * 1024 * 1024
+	pageSize                = 64 * 1024
+)
+
+// Config the config of wasm runtime
+type Config struct {
+	Server    ServerConfig     `yaml:"server" json:"server"`
+	Functions []FunctionConfig `yaml:"functions" json:"functions"`
+}
+
+// ServerConfig the grpc server, the system certificate is used if the certificate isn't specified
+type ServerConfig struct {
+	Address           string        `yaml:"address" json:"address"`
+	Concurrent        LimitConfig   `yaml:"concurrent" json:"concurrent"`
+	Message           MessageConfig `yaml:"message" json:"message"`
+	utils.Certificate `yaml:",inline" json:",inline"`
+}
+
+// MessageConfig the limit of message length, which is 4MB if not specified
+type MessageConfig struct {
+	Length LimitConfig `yaml:"length" json:"length"`
+}
+
+// LimitConfig the limit which is unlimited if zero
+type LimitConfig struct {
+	Max int `yaml:"max" json:"max"`
+}
+
+// FunctionConfig the function whose handler is 'module.export', the module is the wasm file in the code dir
+// without the suffix '.wasm'. Each call runs in a new instance of the module, whose memory is limited and
+// which is closed once the call times out.
+type FunctionConfig struct {
+	Name    string        `yaml:"name" json:"name" validate:"nonzero"`
+	Handler string        `yaml:"handler" json:"handler" validate:"nonzero"`
+	CodeDir string        `yaml:"codedir" json:"codedir"`
+	Timeout time.Duration `yaml:"timeout" json:"timeout" default:"30s"`
+	Memory  int64         `yaml:"memory" json:"memory" default:"16777216"`
+}
+
+type function struct {
+	name    string
+	export  string
+	timeout time.Duration
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+}
+
+// Runtime the wasm runtime, which implements the baetyl.Function grpc server
+type Runtime struct {
+	cfg       Config
+	functions map[string]*function
+	svr       *grpc.Server
+	log       *log.Logger
+}
+
+// NewRuntime compiles the wasm modules of functions in the code path
+func NewRuntime(cfg Config, codePath string) (*Runtime, error) {
+	r := &Runtime{
+		cfg:       cfg,
+		functions: map[string]*function{},
+		log:       log.With(log.Any("runtime", "wasm")),
+	}
+	for _, fc := range cfg.Functions {
+		fn, err := r.load(fc, codePath)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.functions[fc.Name] = fn
+	}
+	return r, nil
+}
+
+func (r *Runtime) load(fc FunctionConfig, codePath string) (*function, error) {
+	if fc.Name == "" || fc.Handler == "" {
+		return nil, errors.New("config invalid, missing function name or handler")
+	}
+	i := strings.LastIndex(fc.Handler, ".")
+	if i <= 0 || i == len(fc.Handler)-1 {
+		return nil, errors.Errorf("config invalid, the handler (%s) of function (%s) isn't 'module.export'", fc.Handler, fc.Name)
+	}
+	bin, err := ioutil.ReadFile(filepath.Join(codePath, fc.CodeDir, fc.Handler[:i]+".wasm"))
+	if err != nil {
+		return nil, errors.Errorf("failed to load function (%s): %s", fc.Name, err.Error())
+	}
+
+	timeout, memory := fc.Timeout, fc.Memory
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	if memory <= 0 {
+		memory = defaultMemory
+	}
+	ctx := context.Background()
+	// each function has its own engine, since the memory limit is set in the config of engine, and it applies
+	// to every instance of the module, which is created per call
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32((memory+pageSize-1)/pageSize)).
+		WithCloseOnContextDone(true))
+	fn := &function{name: fc.Name, export: fc.Handler[i+1:], timeout: timeout, runtime: rt}
+	if err = r.compile(ctx, fn, bin); err != nil {
+		rt.Close(ctx)
+		return nil, errors.Errorf("failed to load function (%s): %s", fc.Name, err.Error())
+	}
+	return fn, nil
+}
+
+func (r *Runtime) compile(ctx context.Context, fn *function, bin []byte) error {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, fn.runtime); err != nil {
+		return errors.Trace(err)
+	}
+	if err := instantiateHostModule(ctx, fn.runtime); err != nil {
+		return errors.Trace(err)
+	}
+	module, err := fn.runtime.CompileModule(ctx, bin)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	def, ok := module.ExportedFunctions()[fn.export]
+	if !ok {
+		return errors.Errorf("the handler (%s) isn't exported", fn.export)
+	}
+	if len(def.ParamTypes()) != 0 || len(def.ResultTypes()) != 1 || def.ResultTypes()[0] != api.ValueTypeI32 {
+		return errors.Errorf("the handler (%s) isn't a function without parameters returning i32", fn.export)
+	}
+	fn.module = module
+	return nil
+}
+
+// Start listens on the address and serves
+func (r *Runtime) Start() error {
+	cfg := r.cfg.Server
+	maxLength := r.maxLength()
+	opts := []grpc.ServerOption{grpc.MaxRecvMsgSize(maxLength), grpc.MaxSendMsgSize(maxLength)}
+	if cfg.Concurrent.Max > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(uint32(cfg.Concurrent.Max)))
+	}
+	if cfg.Key != "" && cfg.Cert != "" {
+		cert := cfg.Certificate
+		if cert.CA != "" {
+			cert.ClientAuthType = tls.RequireAndVerifyClientCert
+		}
+		tlsConfig, err := utils.NewTLSConfigServer(cert)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	ln, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	r.svr = grpc.NewServer(opts...)
+	baetyl.RegisterFunctionServer(r.svr, r)
+	go func() {
+		r.log.Info("service starting", log.Any("address", cfg.Address))
+		if err := r.svr.Serve(ln); err != nil {
+			r.log.Error("service shutdown", log.Error(err))
+		}
+	}()
+	return nil
+}
+
+// maxLength returns the max length of messages, which also limits the output of calls
+func (r *Runtime) maxLength() int {
+	if r.cfg.Server.Message.Length.Max <= 0 {
+		return defaultMaxMessageLength
+	}
+	return r.cfg.Server.Message.Length.Max
+}
+
+// Close stops the server gracefully and releases the engines of functions
+func (r *Runtime) Close() {
+	if r.svr != nil {
+		r.svr.GracefulStop()
+	}
+	for _, fn := range r.functions {
+		fn.runtime.Close(context.Background())
+	}
+	r.log.Info("service closed")
+}
+
+// Call invokes the handler of the function with the payload and the metadata of message. In batch mode, the
+// payload is a json array of events, the handler is invoked for each event, and a json array of results is
+// returned, in which the outputs are put as strings if they aren't json.
+func (r *Runtime) Call(ctx context.Context, msg *baetyl.Message) (*baetyl.Message, error) {
+	name := msg.Metadata["functionName"]
+	if name == "" && len(r.cfg.Functions) > 0 {
+		name = r.cfg.Functions[0].Name
+	}
+	fn, ok := r.functions[name]
+	if !ok {
+		r.log.Error("the function doesn't found", log.Any("function", name))
+		return nil, errdetail.New(codes.NotFound, errdetail.ReasonFunctionNotFound, "the function doesn't found: "+name, false)
+	}
+	if msg.Metadata == nil {
+		msg.Metadata = map[string]string{}
+	}
+	if msg.Metadata["batch"] != "true" {
+		return r.invoke(ctx, fn, msg.Payload, msg.Metadata)
+	}
+
+	var events []json.RawMessage
+	if err := json.Unmarshal(msg.Payload, &events); err != nil {
+		return nil, errdetail.New(codes.InvalidArgument, errdetail.ReasonBadInput, "the batch payload is not a json array", false)
+	}
+	results := make([]json.RawMessage, len(events))
+	for i, event := range events {
+		// non-json payloads are put into batch as strings
+		var s string
+		payload := []byte(event)
+		if json.Unmarshal(event, &s) == nil {
+			payload = []byte(s)
+		}
+		res, err := r.invoke(ctx, fn, payload, msg.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = batchResult(res.Payload)
+	}
+	msg.Payload, _ = json.Marshal(results)
+	return msg, nil
+}
+
+func batchResult(output []byte) json.RawMessage {
+	if len(output) == 0 {
+		return json.RawMessage("null")
+	}
+	if json.Valid(output) {
+		return output
+	}
+	b, _ := json.Marshal(string(output))
+	return b
+}
+
+// invoke runs the handler in a new instance of the module, which is closed once the call is done or times out
+func (r *Runtime) invoke(ctx context.Context, fn *function, payload []byte, metadata map[string]string) (*baetyl.Message, error) {
+	inv := &invocation{
+		function:  fn.name,
+		payload:   payload,
+		metadata:  map[string]string{},
+		maxOutput: r.maxLength(),
+		log:       r.log,
+	}
+	for k, v := range metadata {
+		inv.metadata[k] = v
+	}
+	cctx, cancel := context.WithTimeout(withInvocation(ctx, inv), fn.timeout)
+	defer cancel()
+
+	mod, err := fn.runtime.InstantiateModule(cctx, fn.module, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStdout(os.Stdout).
+		WithStderr(os.Stderr))
+	if err != nil {
+		return nil, r.callError(ctx, cctx, fn, err)
+	}
+	defer mod.Close(context.Background())
+
+	results, err := mod.ExportedFunction(fn.export).Call(cctx)
+	if err != nil {
+		return nil, r.callError(ctx, cctx, fn, err)
+	}
+	if code := int32(results[0]); code != 0 {
+		message := inv.err
+		if message == "" {
+			message = "the handler returns " + strconv.Itoa(int(code))
+		}
+		r.log.Error("error when invoking function", log.Any("function", fn.name), log.Any("error", message))
+		return nil, errdetail.New(codes.Unknown, errdetail.ReasonUserCode, "[UserCodeInvoke] "+message, false)
+	}
+	return &baetyl.Message{Metadata: inv.metadata, Payload: inv.output}, nil
+}
+
+func (r *Runtime) callError(ctx, cctx context.Context, fn *function, err error) error {
+	r.log.Error("error when invoking function", log.Any("function", fn.name), log.Error(err))
+	switch {
+	case ctx.Err() == context.Canceled:
+		return status.Error(codes.Canceled, "the call is cancelled")
+	case cctx.Err() == context.DeadlineExceeded:
+		return errdetail.New(codes.DeadlineExceeded, errdetail.ReasonTimeout, "the call times out", false)
+	default:
+		return errdetail.New(codes.Unknown, errdetail.ReasonUserCode, "[UserCodeInvoke] "+err.Error(), false)
+	}
+}
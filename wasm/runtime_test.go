@@ -0,0 +1,146 @@
+package wasm
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-function/v2/errdetail"
+	baetyl "github.com/baetyl/baetyl-go/v2/faas"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoad(t *testing.T) {
+	cases := map[string]string{
+		"echo":         "config invalid, the handler (echo) of function (f) isn't 'module.export'",
+		"missing.echo": "failed to load function (f): open testdata/missing.wasm: no such file or directory",
+		"echo.missing": "failed to load function (f): the handler (missing) isn't exported",
+		"echo.params":  "failed to load function (f): the handler (params) isn't a function without parameters returning i32",
+	}
+	for handler, msg := range cases {
+		_, err := NewRuntime(Config{Functions: []FunctionConfig{{Name: "f", Handler: handler}}}, "testdata")
+		assert.EqualError(t, err, msg, handler)
+	}
+
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bad.wasm"), []byte("not wasm"), 0644))
+	_, err := NewRuntime(Config{Functions: []FunctionConfig{{Name: "f", Handler: "bad.handle"}}}, dir)
+	assert.EqualError(t, err, "failed to load function (f): invalid magic number")
+}
+
+func TestRuntime(t *testing.T) {
+	cfg := Config{
+		Server: ServerConfig{Address: "127.0.0.1:50082"},
+		Functions: []FunctionConfig{
+			{Name: "echo", Handler: "echo.echo"},
+			{Name: "name", Handler: "echo.name"},
+			{Name: "fail", Handler: "echo.fail"},
+			{Name: "loop", Handler: "echo.loop", Timeout: 100 * time.Millisecond},
+			{Name: "grow", Handler: "echo.grow", Memory: 2 * pageSize},
+			{Name: "grow-unlimited", Handler: "echo.grow"},
+			{Name: "oob", Handler: "echo.oob"},
+		},
+	}
+	r, err := NewRuntime(cfg, "testdata")
+	assert.NoError(t, err)
+	assert.NoError(t, r.Start())
+	defer r.Close()
+
+	conn, err := grpc.Dial(cfg.Server.Address, grpc.WithInsecure(), grpc.WithBlock())
+	assert.NoError(t, err)
+	defer conn.Close()
+	cli := baetyl.NewFunctionClient(conn)
+
+	call := func(function, batch, payload string) (*baetyl.Message, error) {
+		return cli.Call(context.Background(), &baetyl.Message{
+			Metadata: map[string]string{"functionName": function, "batch": batch},
+			Payload:  []byte(payload),
+		})
+	}
+	reasonOf := func(err error) string {
+		st, ok := status.FromError(err)
+		assert.True(t, ok)
+		assert.Len(t, st.Details(), 1)
+		return st.Details()[0].(*structpb.Struct).Fields["reason"].GetStringValue()
+	}
+
+	res, err := call("echo", "", "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(res.Payload))
+	assert.Equal(t, "true", res.Metadata["echoed"])
+	assert.Equal(t, "echo", res.Metadata["functionName"])
+
+	// the first function is used if the function name is empty
+	res, err = call("", "", "")
+	assert.NoError(t, err)
+	assert.Empty(t, res.Payload)
+	assert.Equal(t, "true", res.Metadata["echoed"])
+
+	res, err = call("name", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "name", string(res.Payload))
+
+	res, err = call("echo", "true", `[{"a":1},"text","{\"b\":2}",""]`)
+	assert.NoError(t, err)
+	assert.Equal(t, `[{"a":1},"text",{"b":2},null]`, string(res.Payload))
+
+	_, err = call("grow-unlimited", "", "")
+	assert.NoError(t, err)
+
+	_, err = call("echo", "true", `{}`)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, errdetail.ReasonBadInput, reasonOf(err))
+
+	_, err = call("missing", "", "")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, errdetail.ReasonFunctionNotFound, reasonOf(err))
+
+	_, err = call("fail", "", "")
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.Equal(t, errdetail.ReasonUserCode, reasonOf(err))
+	assert.Equal(t, "[UserCodeInvoke] failed on purpose", status.Convert(err).Message())
+
+	start := time.Now()
+	_, err = call("loop", "", "")
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Equal(t, errdetail.ReasonTimeout, reasonOf(err))
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	_, err = call("grow", "", "")
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.Equal(t, errdetail.ReasonUserCode, reasonOf(err))
+	assert.Contains(t, status.Convert(err).Message(), "unreachable")
+
+	_, err = call("oob", "", "payload")
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "memory access out of range (ptr=65535, len=7)")
+
+	// the instance is dropped after the call, so that the state isn't shared
+	res, err = call("echo", "", "again")
+	assert.NoError(t, err)
+	assert.Equal(t, "again", string(res.Payload))
+}
+
+func TestOutputLimit(t *testing.T) {
+	cfg := Config{
+		Server:    ServerConfig{Message: MessageConfig{Length: LimitConfig{Max: 4}}},
+		Functions: []FunctionConfig{{Name: "echo", Handler: "echo.echo"}},
+	}
+	r, err := NewRuntime(cfg, "testdata")
+	assert.NoError(t, err)
+	defer r.Close()
+
+	res, err := r.Call(context.Background(), &baetyl.Message{Payload: []byte("echo")})
+	assert.NoError(t, err)
+	assert.Equal(t, "echo", string(res.Payload))
+
+	_, err = r.Call(context.Background(), &baetyl.Message{Payload: []byte("hello")})
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "the output exceeds the max message length (4)")
+}
@@ -0,0 +1,53 @@
+;; the module for tests, echo.wasm is assembled from it
+(module
+  (import "baetyl" "payload_size" (func $payload_size (result i32)))
+  (import "baetyl" "payload_read" (func $payload_read (param i32 i32) (result i32)))
+  (import "baetyl" "output_write" (func $output_write (param i32 i32)))
+  (import "baetyl" "metadata_get" (func $metadata_get (param i32 i32 i32 i32) (result i32)))
+  (import "baetyl" "metadata_set" (func $metadata_set (param i32 i32 i32 i32)))
+  (import "baetyl" "error_write" (func $error_write (param i32 i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 0) "functionName")
+  (data (i32.const 16) "echoed")
+  (data (i32.const 32) "true")
+  (data (i32.const 48) "failed on purpose")
+
+  ;; echo returns the payload and sets the metadata 'echoed'
+  (func (export "echo") (result i32)
+    (local $n i32)
+    (local.set $n (call $payload_read (i32.const 1024) (call $payload_size)))
+    (call $output_write (i32.const 1024) (local.get $n))
+    (call $metadata_set (i32.const 16) (i32.const 6) (i32.const 32) (i32.const 4))
+    (i32.const 0))
+
+  ;; name returns the function name in metadata
+  (func (export "name") (result i32)
+    (call $output_write (i32.const 1024)
+      (call $metadata_get (i32.const 0) (i32.const 12) (i32.const 1024) (i32.const 256)))
+    (i32.const 0))
+
+  ;; fail reports an error
+  (func (export "fail") (result i32)
+    (call $error_write (i32.const 48) (i32.const 17))
+    (i32.const 1))
+
+  ;; loop never returns
+  (func (export "loop") (result i32)
+    (loop $l (br $l))
+    (i32.const 0))
+
+  ;; grow grows the memory by 16 pages, and traps if the memory limit is exceeded
+  (func (export "grow") (result i32)
+    (if (i32.eq (memory.grow (i32.const 16)) (i32.const -1))
+      (then unreachable))
+    (i32.const 0))
+
+  ;; oob reads the payload out of the memory
+  (func (export "oob") (result i32)
+    (drop (call $payload_read (i32.const 65535) (i32.const 16)))
+    (i32.const 0))
+
+  ;; params has the wrong signature of handler
+  (func (export "params") (param i32) (result i32)
+    (local.get 0))
+)